@@ -0,0 +1,368 @@
+package commonjs
+
+import (
+	contextpkg "context"
+	"fmt"
+	"reflect"
+
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/file"
+	"github.com/tliron/exturl"
+)
+
+//
+// Analysis
+//
+
+type Analysis struct {
+	Entry   *AnalyzedModule
+	Modules []*AnalyzedModule // in discovery order
+	Cycles  [][]string        // each cycle is a list of module IDs, starting and ending with the same ID
+}
+
+//
+// AnalyzedModule
+//
+
+type AnalyzedModule struct {
+	Id       string
+	URL      exturl.URL
+	Requires []*AnalyzedRequire
+	Err      error // failure to read, precompile, or parse the module
+}
+
+//
+// AnalyzedRequire
+//
+
+type AnalyzedRequire struct {
+	From     *AnalyzedModule
+	Id       string // empty if dynamic
+	Dynamic  bool   // the argument is not a string literal
+	Position file.Position
+	URL      exturl.URL // nil if dynamic or unresolved
+	Err      error      // resolution error
+}
+
+// Statically analyzes the module at id and everything it requires, recursively,
+// without running any code. Modules are read and precompiled (see
+// [Context.Source]) and then parsed. Calls to "require" and
+// "module.require" are resolved with [Environment.CreateResolver] or to
+// virtual modules (see [Environment.DefineModule]) and native modules (see
+// [Environment.NativeModules] and [Extension.Native]).
+//
+// Only calls with a string literal argument can be followed. Other calls are
+// reported as dynamic. Note that shadowing of "require" is not detected.
+//
+// Returns an error only if the entry point itself cannot be resolved. Other
+// problems are reported in the returned [Analysis].
+func (self *Environment) Analyze(id string, bareId bool) (*Analysis, error) {
+	context, cancelContext := self.NewTimeoutContext()
+	defer cancelContext()
+
+	if url, err := self.newResolver(nil, self.newAnalysisContext(nil))(context, id, bareId); err == nil {
+		return self.analyze(context, url), nil
+	} else {
+		return nil, err
+	}
+}
+
+// Like [Environment.Analyze] but starts from an already resolved URL.
+func (self *Environment) AnalyzeURL(url exturl.URL) *Analysis {
+	context, cancelContext := self.NewTimeoutContext()
+	defer cancelContext()
+
+	return self.analyze(context, url)
+}
+
+// Returns all requires that could not be resolved.
+func (self *Analysis) Unresolved() []*AnalyzedRequire {
+	var unresolved []*AnalyzedRequire
+	for _, module := range self.Modules {
+		for _, require := range module.Requires {
+			if !require.Dynamic && (require.Err != nil) {
+				unresolved = append(unresolved, require)
+			}
+		}
+	}
+	return unresolved
+}
+
+// Returns all requires with arguments that are not string literals.
+func (self *Analysis) Dynamic() []*AnalyzedRequire {
+	var dynamic []*AnalyzedRequire
+	for _, module := range self.Modules {
+		for _, require := range module.Requires {
+			if require.Dynamic {
+				dynamic = append(dynamic, require)
+			}
+		}
+	}
+	return dynamic
+}
+
+// Returns all modules that could not be read, precompiled, or parsed.
+func (self *Analysis) Failed() []*AnalyzedModule {
+	var failed []*AnalyzedModule
+	for _, module := range self.Modules {
+		if module.Err != nil {
+			failed = append(failed, module)
+		}
+	}
+	return failed
+}
+
+// True if there are no unresolved requires and no failed modules. Dynamic
+// requires and cycles are not considered problems.
+func (self *Analysis) OK() bool {
+	return (len(self.Unresolved()) == 0) && (len(self.Failed()) == 0)
+}
+
+// ([fmt.Stringer] interface)
+func (self *AnalyzedRequire) String() string {
+	var id string
+	if self.Dynamic {
+		id = "dynamic require"
+	} else {
+		id = fmt.Sprintf("require(%q)", self.Id)
+	}
+
+	if self.Err != nil {
+		return fmt.Sprintf("%s: %s: %s", self.Position, id, self.Err.Error())
+	} else {
+		return fmt.Sprintf("%s: %s", self.Position, id)
+	}
+}
+
+func (self *Environment) analyze(context contextpkg.Context, url exturl.URL) *Analysis {
+	var analysis Analysis
+	modules := make(map[string]*AnalyzedModule)
+
+	var visit func(url exturl.URL) *AnalyzedModule
+	visit = func(url exturl.URL) *AnalyzedModule {
+		key := url.Key()
+		if module, ok := modules[key]; ok {
+			return module
+		}
+
+		module := &AnalyzedModule{
+			Id:  key,
+			URL: url,
+		}
+		modules[key] = module
+		analysis.Modules = append(analysis.Modules, module)
+
+		self.analyzeModule(context, module)
+
+		for _, require := range module.Requires {
+			if require.URL != nil {
+				visit(require.URL)
+			}
+		}
+
+		return module
+	}
+
+	analysis.Entry = visit(url)
+	analysis.Cycles = findCycles(analysis.Modules)
+
+	return &analysis
+}
+
+func (self *Environment) analyzeModule(context contextpkg.Context, module *AnalyzedModule) {
+	if isSourceless(module.URL) {
+		// No source to analyze
+		return
+	}
+
+	jsContext := self.newAnalysisContext(module.URL)
+
+	if program, err := self.parseModule(context, module.URL, jsContext); err == nil {
+		resolve := self.newResolver(module.URL, jsContext)
+
+		seen := make(map[file.Idx]struct{})
+		walkAST(reflect.ValueOf(program), func(node ast.Node) {
+			if call, ok := node.(*ast.CallExpression); ok && isRequireCallee(call.Callee) {
+				// The same node can be reachable more than once (e.g. via DeclarationList)
+				if _, ok := seen[call.LeftParenthesis]; ok {
+					return
+				}
+				seen[call.LeftParenthesis] = struct{}{}
+
+				require := AnalyzedRequire{
+					From:     module,
+					Position: program.File.Position(int(call.Idx0()) - program.File.Base()),
+				}
+
+				if id, ok := literalString(call.ArgumentList); ok {
+					require.Id = id
+					require.URL, require.Err = resolve(context, id, false)
+				} else {
+					require.Dynamic = true
+				}
+
+				module.Requires = append(module.Requires, &require)
+			}
+		})
+	} else {
+		module.Err = err
+	}
+}
+
+func (self *Environment) parseModule(context contextpkg.Context, url exturl.URL, jsContext *Context) (*ast.Program, error) {
+	if script, err := jsContext.Source(context); err == nil {
+		// Parse in the wrapper so that top-level "return" is allowed
+		return parseWrappedModule(url.String(), script, jsContext.SourceMap, jsContext.SelectedExtensions)
+	} else {
+		return nil, err
+	}
+}
+
+func (self *Environment) newAnalysisContext(url exturl.URL) *Context {
+	var module Module
+	if url != nil {
+		module.Id = url.Key()
+		if fileUrl, ok := url.(*exturl.FileURL); ok {
+			module.Filename = fileUrl.Path
+		}
+	}
+
+	jsContext := Context{
+		Environment: self,
+		URL:         url,
+		Module:      &module,
+	}
+
+	if url != nil {
+		jsContext.SelectedExtensions = self.selectExtensions(&jsContext)
+	}
+
+	return &jsContext
+}
+
+// Matches "require" and "module.require".
+func isRequireCallee(callee ast.Expression) bool {
+	switch callee_ := callee.(type) {
+	case *ast.Identifier:
+		return callee_.Name == "require"
+
+	case *ast.DotExpression:
+		if left, ok := callee_.Left.(*ast.Identifier); ok {
+			return (left.Name == "module") && (callee_.Identifier.Name == "require")
+		}
+	}
+
+	return false
+}
+
+func literalString(arguments []ast.Expression) (string, bool) {
+	if len(arguments) != 1 {
+		return "", false
+	}
+
+	switch argument := arguments[0].(type) {
+	case *ast.StringLiteral:
+		return argument.Value.String(), true
+
+	case *ast.TemplateLiteral:
+		// Template literals without substitutions
+		if (argument.Tag == nil) && (len(argument.Expressions) == 0) && (len(argument.Elements) == 1) {
+			return argument.Elements[0].Parsed.String(), true
+		}
+	}
+
+	return "", false
+}
+
+var astFileType = reflect.TypeOf((*file.File)(nil))
+
+// goja does not provide an AST visitor, so we walk the exported fields of the
+// nodes via reflection.
+func walkAST(value reflect.Value, visit func(node ast.Node)) {
+	switch value.Kind() {
+	case reflect.Interface:
+		if !value.IsNil() {
+			walkAST(value.Elem(), visit)
+		}
+
+	case reflect.Pointer:
+		if value.IsNil() || (value.Type() == astFileType) {
+			return
+		}
+
+		if node, ok := value.Interface().(ast.Node); ok {
+			visit(node)
+		}
+
+		walkAST(value.Elem(), visit)
+
+	case reflect.Struct:
+		type_ := value.Type()
+		for index := range value.NumField() {
+			if type_.Field(index).IsExported() {
+				walkAST(value.Field(index), visit)
+			}
+		}
+
+	case reflect.Slice:
+		for index := range value.Len() {
+			walkAST(value.Index(index), visit)
+		}
+	}
+}
+
+func findCycles(modules []*AnalyzedModule) [][]string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	var cycles [][]string
+	state := make(map[string]int)
+	byId := make(map[string]*AnalyzedModule)
+	for _, module := range modules {
+		byId[module.Id] = module
+	}
+	var stack []string
+
+	var visit func(module *AnalyzedModule)
+	visit = func(module *AnalyzedModule) {
+		state[module.Id] = visiting
+		stack = append(stack, module.Id)
+
+		for _, require := range module.Requires {
+			if require.URL == nil {
+				continue
+			}
+
+			key := require.URL.Key()
+			switch state[key] {
+			case unvisited:
+				if child, ok := byId[key]; ok {
+					visit(child)
+				}
+
+			case visiting:
+				for index, id := range stack {
+					if id == key {
+						cycle := append([]string{}, stack[index:]...)
+						cycles = append(cycles, append(cycle, key))
+						break
+					}
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[module.Id] = visited
+	}
+
+	for _, module := range modules {
+		if state[module.Id] == unvisited {
+			visit(module)
+		}
+	}
+
+	return cycles
+}
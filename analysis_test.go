@@ -0,0 +1,45 @@
+package commonjs_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/exturl"
+)
+
+func TestAnalyze(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	path := filepath.Join(getRoot(t), "examples")
+
+	environment := commonjs.NewEnvironment(urlContext, urlContext.NewFileURL(path))
+	defer environment.Release()
+
+	if analysis, err := environment.Analyze("./start", false); err == nil {
+		if !analysis.OK() {
+			t.Errorf("unexpected problems: %v %v", analysis.Unresolved(), analysis.Failed())
+		}
+		if len(analysis.Modules) != 2 {
+			t.Errorf("expected 2 modules, got %d", len(analysis.Modules))
+		}
+	} else {
+		t.Fatal(err)
+	}
+
+	exturl.UpdateInternalURL("/commonjs-test/analyze/a.js", "require('./b');\nconst name = 'c';\nrequire(name);\nrequire('./missing');")
+	exturl.UpdateInternalURL("/commonjs-test/analyze/b.js", "module.require(`./a`);")
+
+	analysis := environment.AnalyzeURL(urlContext.NewInternalURL("/commonjs-test/analyze/a.js"))
+
+	if unresolved := analysis.Unresolved(); (len(unresolved) != 1) || (unresolved[0].Id != "./missing") || (unresolved[0].Position.Line != 4) {
+		t.Errorf("unexpected unresolved: %v", unresolved)
+	}
+	if dynamic := analysis.Dynamic(); (len(dynamic) != 1) || (dynamic[0].Position.Line != 3) {
+		t.Errorf("unexpected dynamic: %v", dynamic)
+	}
+	if len(analysis.Cycles) != 1 {
+		t.Errorf("expected 1 cycle, got %v", analysis.Cycles)
+	}
+}
@@ -11,21 +11,37 @@ import (
 //
 
 type DefaultExtensions struct {
-	LateBind  bool
-	Arguments map[string]string
-	Stdout    io.Writer
-	Stderr    io.Writer
+	LateBind   bool
+	Rebind     bool // takes precedence over LateBind
+	PooledBind bool // takes precedence over LateBind and Rebind
+	PoolSize   int  // for PooledBind; if <= 0 will be the number of CPUs
+	Globals    bool // see commonjs.Extension.Global
+	Lazy       bool // see commonjs.Extension.Lazy
+	Arguments  map[string]string
+	Stdout     io.Writer
+	Stderr     io.Writer
 }
 
+// Sets the extensions as [commonjs.Environment.Extensions].
+func (self DefaultExtensions) Install(environment *commonjs.Environment) {
+	environment.Extensions = self.Create()
+}
+
+// The extensions are [commonjs.Extension.Native], so that they are also
+// available via "require", e.g. require('console').
 func (self DefaultExtensions) Create() []commonjs.Extension {
 	var createBind commonjs.CreateExtensionFunc
-	if self.LateBind {
+	if self.PooledBind {
+		createBind = CreatePooledBindExtension(self.PoolSize)
+	} else if self.Rebind {
+		createBind = CreateRebindExtension
+	} else if self.LateBind {
 		createBind = CreateLateBindExtension
 	} else {
 		createBind = CreateEarlyBindExtension
 	}
 
-	return []commonjs.Extension{{
+	extensions := []commonjs.Extension{{
 		Name:   "bind",
 		Create: createBind,
 	}, {
@@ -46,5 +62,22 @@ func (self DefaultExtensions) Create() []commonjs.Extension {
 	}, {
 		Name:   "os",
 		Create: CreateOSExtension,
+	}, {
+		Name:   "concurrent",
+		Create: CreateConcurrentExtension,
+	}, {
+		Name:   "state",
+		Create: CreateStateExtension,
+	}, {
+		Name:   "Worker",
+		Create: CreateWorkerExtension,
 	}}
+
+	for index := range extensions {
+		extensions[index].Global = self.Globals
+		extensions[index].Lazy = self.Lazy
+		extensions[index].Native = true
+	}
+
+	return extensions
 }
@@ -0,0 +1,358 @@
+package api
+
+import (
+	contextpkg "context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/tliron/commonjs-goja"
+)
+
+// ([commonjs.CreateExtensionFunc] signature)
+func CreateConcurrentExtension(jsContext *commonjs.Context) any {
+	return NewConcurrent(jsContext.Environment.Runtime, GetConcurrentRegistry(jsContext.Environment))
+}
+
+//
+// ConcurrentRegistry
+//
+
+// Named concurrency primitives.
+//
+// Safe to share between goroutines and environments.
+type ConcurrentRegistry struct {
+	channels   sync.Map
+	waitGroups sync.Map
+	semaphores sync.Map
+	atomics    sync.Map
+}
+
+func NewConcurrentRegistry() *ConcurrentRegistry {
+	return new(ConcurrentRegistry)
+}
+
+type concurrentRegistryKey struct{}
+
+// Returns the registry that is shared by the environment's root and all its
+// descendants (see [commonjs.Environment.Shared]), e.g. workers and binds.
+func GetConcurrentRegistry(environment *commonjs.Environment) *ConcurrentRegistry {
+	return environment.Shared(concurrentRegistryKey{}, func() any {
+		return NewConcurrentRegistry()
+	}).(*ConcurrentRegistry)
+}
+
+// Returns the channel with the name, creating it if it doesn't exist. Capacity
+// is ignored if it exists.
+func (self *ConcurrentRegistry) Channel(name string, capacity int) (*commonjs.Channel, error) {
+	if channel, ok := self.channels.Load(name); ok {
+		return channel.(*commonjs.Channel), nil
+	}
+
+	if channel, err := commonjs.NewChannel(capacity); err == nil {
+		channel_, _ := self.channels.LoadOrStore(name, channel)
+		return channel_.(*commonjs.Channel), nil
+	} else {
+		return nil, err
+	}
+}
+
+// Returns the wait group with the name, creating it if it doesn't exist.
+func (self *ConcurrentRegistry) WaitGroup(name string) *commonjs.WaitGroup {
+	waitGroup, _ := self.waitGroups.LoadOrStore(name, commonjs.NewWaitGroup())
+	return waitGroup.(*commonjs.WaitGroup)
+}
+
+// Returns the semaphore with the name, creating it if it doesn't exist. Size is
+// ignored if it exists.
+func (self *ConcurrentRegistry) Semaphore(name string, size int) (*commonjs.Semaphore, error) {
+	if semaphore, ok := self.semaphores.Load(name); ok {
+		return semaphore.(*commonjs.Semaphore), nil
+	}
+
+	if semaphore, err := commonjs.NewSemaphore(size); err == nil {
+		semaphore_, _ := self.semaphores.LoadOrStore(name, semaphore)
+		return semaphore_.(*commonjs.Semaphore), nil
+	} else {
+		return nil, err
+	}
+}
+
+// Returns the atomic integer with the name, creating it (as 0) if it doesn't
+// exist.
+func (self *ConcurrentRegistry) Atomic(name string) *atomic.Int64 {
+	atomic_, _ := self.atomics.LoadOrStore(name, new(atomic.Int64))
+	return atomic_.(*atomic.Int64)
+}
+
+// Removes the primitives with the name from the registry. Those already in use
+// keep working, but will not be returned again.
+func (self *ConcurrentRegistry) Delete(name string) {
+	self.channels.Delete(name)
+	self.waitGroups.Delete(name)
+	self.semaphores.Delete(name)
+	self.atomics.Delete(name)
+}
+
+//
+// Concurrent
+//
+
+// Concurrency primitives for JavaScript. Primitives with the same name are
+// shared via the registry (see [GetConcurrentRegistry]), while an empty name
+// creates a new primitive.
+//
+// Blocking operations accept a timeout in seconds, where <= 0 means no
+// timeout. Note that a blocked runtime cannot be interrupted, e.g. by
+// terminating a [commonjs.Worker].
+type Concurrent struct {
+	runtime  *goja.Runtime
+	registry *ConcurrentRegistry
+}
+
+func NewConcurrent(runtime *goja.Runtime, registry *ConcurrentRegistry) *Concurrent {
+	return &Concurrent{
+		runtime:  runtime,
+		registry: registry,
+	}
+}
+
+// Capacity cannot be negative.
+func (self *Concurrent) Channel(name string, capacity int) (*ChannelObject, error) {
+	var channel *commonjs.Channel
+	var err error
+	if name == "" {
+		channel, err = commonjs.NewChannel(capacity)
+	} else {
+		channel, err = self.registry.Channel(name, capacity)
+	}
+
+	if err == nil {
+		return NewChannelObject(self.runtime, channel), nil
+	} else {
+		return nil, err
+	}
+}
+
+func (self *Concurrent) WaitGroup(name string) *WaitGroupObject {
+	if name == "" {
+		return &WaitGroupObject{commonjs.NewWaitGroup()}
+	} else {
+		return &WaitGroupObject{self.registry.WaitGroup(name)}
+	}
+}
+
+// If size is 0 (e.g. if it is omitted) then it will be 1. It cannot be
+// negative.
+func (self *Concurrent) Semaphore(name string, size int) (*SemaphoreObject, error) {
+	if size == 0 {
+		size = 1
+	}
+
+	var semaphore *commonjs.Semaphore
+	var err error
+	if name == "" {
+		semaphore, err = commonjs.NewSemaphore(size)
+	} else {
+		semaphore, err = self.registry.Semaphore(name, size)
+	}
+
+	if err == nil {
+		return &SemaphoreObject{semaphore}, nil
+	} else {
+		return nil, err
+	}
+}
+
+// Has "load", "store", "add", "swap", and "compareAndSwap".
+func (self *Concurrent) Atomic(name string) *atomic.Int64 {
+	if name == "" {
+		return new(atomic.Int64)
+	} else {
+		return self.registry.Atomic(name)
+	}
+}
+
+// See [ConcurrentRegistry.Delete].
+func (self *Concurrent) Delete(name string) {
+	self.registry.Delete(name)
+}
+
+// Cases are objects with either "receive" (a channel) or "send" (a channel) and
+// "value". Returns {index, value, ok} for the case that proceeded, or null on
+// timeout. See [commonjs.Select].
+func (self *Concurrent) Select(cases []*goja.Object, timeoutSeconds float64) (goja.Value, error) {
+	cases_ := make([]commonjs.SelectCase, len(cases))
+	for index, case_ := range cases {
+		if channel, ok := exportChannelObject(case_.Get("receive")); ok {
+			cases_[index] = commonjs.SelectCase{Channel: channel.channel}
+		} else if channel, ok := exportChannelObject(case_.Get("send")); ok {
+			cases_[index] = commonjs.SelectCase{Channel: channel.channel, Send: true, Value: case_.Get("value")}
+		} else {
+			return nil, fmt.Errorf("select case %d has neither \"receive\" nor \"send\" channel", index)
+		}
+	}
+
+	context, cancelContext := newTimeoutContext(timeoutSeconds)
+	defer cancelContext()
+
+	if index, value, ok, err := commonjs.Select(context, self.runtime, cases_); err == nil {
+		result := self.runtime.NewObject()
+		result.Set("index", index)
+		if value != nil {
+			result.Set("value", value)
+		}
+		result.Set("ok", ok)
+		return result, nil
+	} else if isTimeout(err) {
+		return goja.Null(), nil
+	} else {
+		return nil, err
+	}
+}
+
+//
+// ChannelObject
+//
+
+// Wraps a [commonjs.Channel] for a runtime.
+type ChannelObject struct {
+	channel *commonjs.Channel
+	runtime *goja.Runtime
+}
+
+func NewChannelObject(runtime *goja.Runtime, channel *commonjs.Channel) *ChannelObject {
+	return &ChannelObject{
+		channel: channel,
+		runtime: runtime,
+	}
+}
+
+// Returns false on timeout.
+func (self *ChannelObject) Send(value goja.Value, timeoutSeconds float64) (bool, error) {
+	context, cancelContext := newTimeoutContext(timeoutSeconds)
+	defer cancelContext()
+
+	if err := self.channel.Send(context, self.runtime, value); err == nil {
+		return true, nil
+	} else if isTimeout(err) {
+		return false, nil
+	} else {
+		return false, err
+	}
+}
+
+// Returns {value, ok}, where ok is false if the channel is closed, or null on
+// timeout.
+func (self *ChannelObject) Receive(timeoutSeconds float64) (goja.Value, error) {
+	context, cancelContext := newTimeoutContext(timeoutSeconds)
+	defer cancelContext()
+
+	if value, ok, err := self.channel.Receive(context, self.runtime); err == nil {
+		result := self.runtime.NewObject()
+		result.Set("value", value)
+		result.Set("ok", ok)
+		return result, nil
+	} else if isTimeout(err) {
+		return goja.Null(), nil
+	} else {
+		return nil, err
+	}
+}
+
+func (self *ChannelObject) Close() error {
+	return self.channel.Close()
+}
+
+func (self *ChannelObject) Len() int {
+	return self.channel.Len()
+}
+
+//
+// WaitGroupObject
+//
+
+// Wraps a [commonjs.WaitGroup] for a runtime.
+type WaitGroupObject struct {
+	waitGroup *commonjs.WaitGroup
+}
+
+func (self *WaitGroupObject) Add(delta int) error {
+	return self.waitGroup.Add(delta)
+}
+
+func (self *WaitGroupObject) Done() error {
+	return self.waitGroup.Done()
+}
+
+// Returns false on timeout.
+func (self *WaitGroupObject) Wait(timeoutSeconds float64) (bool, error) {
+	context, cancelContext := newTimeoutContext(timeoutSeconds)
+	defer cancelContext()
+
+	if err := self.waitGroup.Wait(context); err == nil {
+		return true, nil
+	} else if isTimeout(err) {
+		return false, nil
+	} else {
+		return false, err
+	}
+}
+
+//
+// SemaphoreObject
+//
+
+// Wraps a [commonjs.Semaphore] for a runtime.
+type SemaphoreObject struct {
+	semaphore *commonjs.Semaphore
+}
+
+// Returns false on timeout.
+func (self *SemaphoreObject) Acquire(timeoutSeconds float64) (bool, error) {
+	context, cancelContext := newTimeoutContext(timeoutSeconds)
+	defer cancelContext()
+
+	if err := self.semaphore.Acquire(context); err == nil {
+		return true, nil
+	} else if isTimeout(err) {
+		return false, nil
+	} else {
+		return false, err
+	}
+}
+
+func (self *SemaphoreObject) TryAcquire() bool {
+	return self.semaphore.TryAcquire()
+}
+
+func (self *SemaphoreObject) Release() error {
+	return self.semaphore.Release()
+}
+
+// Utils
+
+// If timeoutSeconds is <= 0 there is no timeout.
+func newTimeoutContext(timeoutSeconds float64) (contextpkg.Context, contextpkg.CancelFunc) {
+	if timeoutSeconds > 0.0 {
+		return contextpkg.WithTimeout(contextpkg.Background(), time.Duration(timeoutSeconds*float64(time.Second)))
+	} else {
+		return contextpkg.WithCancel(contextpkg.Background())
+	}
+}
+
+func isTimeout(err error) bool {
+	return errors.Is(err, contextpkg.DeadlineExceeded)
+}
+
+func exportChannelObject(value goja.Value) (*ChannelObject, bool) {
+	if value != nil {
+		if channel, ok := value.Export().(*ChannelObject); ok {
+			return channel, true
+		}
+	}
+	return nil, false
+}
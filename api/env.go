@@ -18,6 +18,8 @@ func CreateEnvExtension(arguments map[string]string) commonjs.CreateExtensionFun
 	}
 }
 
+// Note that values belong to the runtime that set them. See [GetSharedState] for
+// values that can be safely used from any runtime.
 var Variables = commonjs.NewThreadSafeObject()
 
 //
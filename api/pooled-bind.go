@@ -0,0 +1,19 @@
+package api
+
+import (
+	"github.com/tliron/commonjs-goja"
+)
+
+// If size is <= 0 then it will be the number of CPUs.
+func CreatePooledBindExtension(size int) commonjs.CreateExtensionFunc {
+	return func(jsContext *commonjs.Context) any {
+		// commonjs.BindFunc signature
+		return func(id string, exportName string) (any, error) {
+			if pooledBind, err := jsContext.NewPooledBind(id, exportName, size); err == nil {
+				return pooledBind, nil
+			} else {
+				return nil, err
+			}
+		}
+	}
+}
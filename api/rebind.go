@@ -0,0 +1,17 @@
+package api
+
+import (
+	"github.com/tliron/commonjs-goja"
+)
+
+// ([commonjs.CreateExtensionFunc] signature)
+func CreateRebindExtension(jsContext *commonjs.Context) any {
+	// commonjs.BindFunc signature
+	return func(id string, exportName string) (any, error) {
+		if rebind, err := jsContext.NewRebind(id, exportName); err == nil {
+			return rebind, nil
+		} else {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/dop251/goja"
+	"github.com/tliron/commonjs-goja"
+)
+
+// ([commonjs.CreateExtensionFunc] signature)
+func CreateStateExtension(jsContext *commonjs.Context) any {
+	return NewState(jsContext.Environment)
+}
+
+type sharedStatesKey struct{}
+
+// Returns the shared state for the namespace, creating it if it doesn't exist.
+// It is shared by the environment's root and all its descendants (see
+// [commonjs.Environment.Shared]), e.g. workers and binds.
+func GetSharedState(environment *commonjs.Environment, namespace string) *commonjs.SharedState {
+	sharedStates := getSharedStates(environment)
+	if sharedState, ok := sharedStates.Load(namespace); ok {
+		return sharedState.(*commonjs.SharedState)
+	}
+	sharedState, _ := sharedStates.LoadOrStore(namespace, commonjs.NewSharedState())
+	return sharedState.(*commonjs.SharedState)
+}
+
+// Removes the namespace, so that [GetSharedState] will create a new one. The
+// old one keeps working for those already using it.
+func DeleteSharedState(environment *commonjs.Environment, namespace string) {
+	getSharedStates(environment).Delete(namespace)
+}
+
+func getSharedStates(environment *commonjs.Environment) *sync.Map {
+	return environment.Shared(sharedStatesKey{}, func() any {
+		return new(sync.Map)
+	}).(*sync.Map)
+}
+
+//
+// State
+//
+
+type State struct {
+	environment *commonjs.Environment
+}
+
+func NewState(environment *commonjs.Environment) *State {
+	return &State{
+		environment: environment,
+	}
+}
+
+// See [GetSharedState].
+func (self *State) Namespace(namespace string) *StateNamespace {
+	return NewStateNamespace(self.environment, GetSharedState(self.environment, namespace))
+}
+
+// See [DeleteSharedState].
+func (self *State) Delete(namespace string) {
+	DeleteSharedState(self.environment, namespace)
+}
+
+//
+// StateNamespace
+//
+
+// Wraps a [commonjs.SharedState] for an environment.
+type StateNamespace struct {
+	sharedState *commonjs.SharedState
+	environment *commonjs.Environment
+}
+
+func NewStateNamespace(environment *commonjs.Environment, sharedState *commonjs.SharedState) *StateNamespace {
+	return &StateNamespace{
+		sharedState: sharedState,
+		environment: environment,
+	}
+}
+
+// Returns undefined if the key does not exist.
+func (self *StateNamespace) Get(key string) (goja.Value, error) {
+	value, _, err := self.sharedState.Get(self.environment.Runtime, key)
+	return value, err
+}
+
+func (self *StateNamespace) Set(key string, value goja.Value) error {
+	return self.sharedState.Set(self.environment.Runtime, key, value)
+}
+
+func (self *StateNamespace) Delete(key string) bool {
+	return self.sharedState.Delete(key)
+}
+
+func (self *StateNamespace) Has(key string) bool {
+	return self.sharedState.Has(key)
+}
+
+func (self *StateNamespace) Keys() []string {
+	return self.sharedState.Keys()
+}
+
+func (self *StateNamespace) CompareAndSwap(key string, expected goja.Value, value goja.Value) (bool, error) {
+	return self.sharedState.CompareAndSwap(self.environment.Runtime, key, expected, value)
+}
+
+// The function is called with the current value and returns the new value. It
+// may be called more than once. Returns the new value.
+func (self *StateNamespace) Update(key string, update goja.Value) (goja.Value, error) {
+	if update_, ok := goja.AssertFunction(update); ok {
+		return self.sharedState.Update(self.environment.Runtime, key, func(value goja.Value) (goja.Value, error) {
+			return update_(nil, value)
+		})
+	} else {
+		return nil, fmt.Errorf("not a function: %T", update)
+	}
+}
+
+// The function is called with {key, value, oldValue, deleted} for every change
+// of the key, or of all keys if key is empty. Returns a function that
+// unsubscribes. The subscription ends when the environment is released.
+//
+// Note that the function is called via [commonjs.Environment.Execute].
+func (self *StateNamespace) Subscribe(key string, notify goja.Value) (func(), error) {
+	if notify_, ok := goja.AssertFunction(notify); ok {
+		return self.sharedState.Subscribe(self.environment, key, notify_), nil
+	} else {
+		return nil, fmt.Errorf("not a function: %T", notify)
+	}
+}
@@ -210,7 +210,9 @@ func (self *Util) Once(name string, value goja.Value, this goja.Value, arguments
 	}
 }
 
-// Goroutine
+// Goroutine. Note that the function runs in the same runtime, which is not safe
+// if anything else is using it at the same time. See [commonjs.Worker] for an
+// alternative.
 func (self *Util) Go(value goja.Value, this goja.Value, arguments []goja.Value) error {
 	if call, ok := goja.AssertFunction(value); ok {
 		call_ := func() error {
@@ -0,0 +1,17 @@
+package api
+
+import (
+	"github.com/dop251/goja"
+	"github.com/tliron/commonjs-goja"
+)
+
+// Creates the "Worker" constructor. See [commonjs.Worker].
+func CreateWorkerExtension(jsContext *commonjs.Context) any {
+	return commonjs.NewConstructor(jsContext.Environment.Runtime, func(constructor goja.ConstructorCall) (any, error) {
+		if worker, err := jsContext.NewWorker(constructor.Argument(0).String()); err == nil {
+			return worker.Object, nil
+		} else {
+			return nil, err
+		}
+	})
+}
@@ -1,6 +1,10 @@
 package commonjs
 
 import (
+	contextpkg "context"
+	"sync"
+
+	"github.com/dop251/goja"
 	"github.com/tliron/exturl"
 )
 
@@ -41,8 +45,8 @@ type EarlyBind struct {
 	err     error
 }
 
-// Will attempt to immediately require the id and export the result, storing the
-// result in an [EarlyBind].
+// Will attempt to immediately require the id and export the result as a proxy
+// (see [Context.RequireAndProxy]), storing the result in an [EarlyBind].
 func (self *Context) NewEarlyBind(id string, exportName string) (EarlyBind, error) {
 	context, cancelContext := self.Environment.NewTimeoutContext()
 	defer cancelContext()
@@ -51,7 +55,7 @@ func (self *Context) NewEarlyBind(id string, exportName string) (EarlyBind, erro
 
 	var url exturl.URL
 	if url, earlyBind.err = self.ResolveAndWatch(context, id, false); earlyBind.err == nil {
-		earlyBind.value, earlyBind.context, earlyBind.err = self.RequireAndExport(context, url, true, nil, exportName)
+		earlyBind.value, earlyBind.context, earlyBind.err = self.RequireAndProxy(context, url, exportName)
 	}
 
 	return earlyBind, earlyBind.err
@@ -70,28 +74,40 @@ func (self EarlyBind) Unbind() (any, *Context, error) {
 // LateBind
 //
 
+// Safe to share between goroutines. Copies share the same cache.
 type LateBind struct {
-	EarlyBind
+	state *lateBindState
+}
 
+type lateBindState struct {
+	jsContext  *Context
 	url        exturl.URL
 	exportName string
 
-	unbound bool
+	value        any
+	boundContext *Context
+	err          error
+	unbound      bool
+	modified     int64 // see: Environment.modifications
+	lock         sync.Mutex
 }
 
 // Will resolve the id and store the URL and exportName in a [LateBind]. Only when
 // [LateBind.Unbind] is called will require the URL and export the result (and cache
 // the return values).
+//
+// The cache is invalidated when the bound file is modified, if the
+// environment's watcher is running (see [Environment.StartWatcher]).
 func (self *Context) NewLateBind(id string, exportName string) (LateBind, error) {
 	context, cancelContext := self.Environment.NewTimeoutContext()
 	defer cancelContext()
 
 	if url, err := self.ResolveAndWatch(context, id, false); err == nil {
 		return LateBind{
-			url:        url,
-			exportName: exportName,
-			EarlyBind: EarlyBind{
-				context: self,
+			state: &lateBindState{
+				jsContext:  self,
+				url:        url,
+				exportName: exportName,
 			},
 		}, nil
 	} else {
@@ -101,15 +117,97 @@ func (self *Context) NewLateBind(id string, exportName string) (LateBind, error)
 
 // ([Bind] interface)
 func (self LateBind) Unbind() (any, *Context, error) {
-	if self.unbound {
-		return self.EarlyBind.Unbind()
+	state := self.state
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	environment := state.jsContext.Environment
+	key := state.url.Key()
+
+	modified := environment.modificationCount(key)
+	if state.unbound {
+		if modified == state.modified {
+			return state.value, state.boundContext, state.err
+		}
+
+		// The bound file was modified, so the compiled program is stale
+		environment.uncacheProgram(key)
 	}
 
-	self.unbound = true
+	state.unbound = true
+	state.modified = modified
 
-	context, cancelContext := self.context.Environment.NewTimeoutContext()
+	context, cancelContext := environment.NewTimeoutContext()
 	defer cancelContext()
 
-	self.value, self.context, self.err = self.context.RequireAndExport(context, self.url, true, nil, self.exportName)
-	return self.value, self.context, self.err
+	state.value, state.boundContext, state.err = state.jsContext.RequireAndProxy(context, state.url, state.exportName)
+	return state.value, state.boundContext, state.err
+}
+
+//
+// Rebind
+//
+
+// Like [LateBind] but without the cache: every call to [Rebind.Unbind] will
+// require the URL in a new child [Environment] and export the result. Safe to
+// share between goroutines.
+type Rebind struct {
+	jsContext  *Context
+	url        exturl.URL
+	exportName string
+}
+
+// Will resolve the id and store the URL and exportName in a [Rebind].
+func (self *Context) NewRebind(id string, exportName string) (Rebind, error) {
+	context, cancelContext := self.Environment.NewTimeoutContext()
+	defer cancelContext()
+
+	if url, err := self.ResolveAndWatch(context, id, false); err == nil {
+		return Rebind{
+			jsContext:  self,
+			url:        url,
+			exportName: exportName,
+		}, nil
+	} else {
+		return Rebind{}, err
+	}
+}
+
+// ([Bind] interface)
+func (self Rebind) Unbind() (any, *Context, error) {
+	context, cancelContext := self.jsContext.Environment.NewTimeoutContext()
+	defer cancelContext()
+
+	return self.jsContext.RequireAndProxy(context, self.url, self.exportName)
+}
+
+// Requires the URL in a new child [Environment] and returns a proxy for its
+// exports, or for one export if exportName is not empty. The proxy can be safely
+// used from other goroutines and runtimes. See [Environment.NewProxy].
+func (self *Context) RequireAndProxy(context contextpkg.Context, url exturl.URL, exportName string) (any, *Context, error) {
+	if exports, jsContext, err := self.Require(context, url, true, nil); err == nil {
+		if proxy, err := jsContext.newExportsProxy(exports, exportName); err == nil {
+			return proxy, jsContext, nil
+		} else {
+			return nil, nil, err
+		}
+	} else {
+		return nil, nil, err
+	}
+}
+
+func (self *Context) newExportsProxy(exports *goja.Object, exportName string) (any, error) {
+	environment := self.Environment
+
+	var proxy any
+	err := environment.Execute(func() error {
+		var err error
+		if exportName == "" {
+			proxy, err = environment.NewProxy(exports)
+		} else {
+			proxy, err = environment.NewPropertyProxy(exports, exportName)
+		}
+		return err
+	})
+	return proxy, err
 }
@@ -0,0 +1,481 @@
+package commonjs_test
+
+import (
+	contextpkg "context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/commonjs-goja/api"
+	"github.com/tliron/exturl"
+)
+
+func TestLateBind(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	environment.DefineModuleSource("bound", "exports.value = 'hello';")
+
+	jsContext := environment.NewContext(nil, nil, nil)
+
+	lateBind, err := jsContext.NewLateBind("bound", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	contexts := make([]*commonjs.Context, 10)
+	for index := range contexts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, boundContext, err := commonjs.Unbind(lateBind, jsContext); err == nil {
+				if value != "hello" {
+					t.Errorf("unexpected value: %v", value)
+				}
+				contexts[index] = boundContext
+			} else {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, boundContext := range contexts {
+		if boundContext != contexts[0] {
+			t.Error("late bind was not memoized")
+			break
+		}
+	}
+
+	// Copies share the cache
+	lateBind2 := lateBind
+	if _, boundContext, err := lateBind2.Unbind(); (err != nil) || (boundContext != contexts[0]) {
+		t.Errorf("late bind copy was not memoized: %v", err)
+	}
+
+	rebind, err := jsContext.NewRebind("bound", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, boundContext1, err := rebind.Unbind()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, boundContext2, err := rebind.Unbind()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if boundContext1 == boundContext2 {
+		t.Error("rebind was memoized")
+	}
+}
+
+func TestLateBindInvalidation(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	path := t.TempDir()
+	file := filepath.Join(path, "bound.js")
+	if err := os.WriteFile(file, []byte("exports.value = 'before';"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	environment := commonjs.NewEnvironment(urlContext, urlContext.NewFileURL(path))
+	defer environment.Release()
+
+	environment.OnFileModified = func(id string, module *commonjs.Module) {}
+	if err := environment.StartWatcher(); err != nil {
+		t.Fatal(err)
+	}
+
+	jsContext := environment.NewContext(nil, nil, nil)
+
+	lateBind, err := jsContext.NewLateBind("./bound", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, _, err := lateBind.Unbind(); (err != nil) || (value != "before") {
+		t.Fatalf("unexpected value: %v %v", value, err)
+	}
+
+	// Without OnFileModified there is no watcher
+	unwatched := commonjs.NewEnvironment(urlContext, urlContext.NewFileURL(path))
+	defer unwatched.Release()
+
+	if err := unwatched.StartWatcher(); err != nil {
+		t.Fatal(err)
+	}
+
+	unwatchedLateBind, err := unwatched.NewContext(nil, nil, nil).NewLateBind("./bound", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, _, err := unwatchedLateBind.Unbind(); (err != nil) || (value != "before") {
+		t.Fatalf("unexpected value: %v %v", value, err)
+	}
+
+	if err := os.WriteFile(file, []byte("exports.value = 'after';"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if value, _, err := lateBind.Unbind(); err == nil {
+			if value == "after" {
+				break
+			}
+		} else {
+			t.Fatal(err)
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("late bind was not invalidated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if value, _, err := unwatchedLateBind.Unbind(); (err != nil) || (value != "before") {
+		t.Errorf("unwatched late bind was invalidated: %v %v", value, err)
+	}
+}
+
+func TestBindProxy(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	environment.DefineModuleSource("counter", `
+exports.count = 0;
+exports.increment = function() { return ++this.count; };
+exports.mutate = function(object) { object.value = 'changed'; return object; };
+exports.bytes = new Uint8Array([1, 2, 3]);
+exports.firstByte = function() { return exports.bytes[0]; };
+exports.fill = function(bytes) { bytes[0] = 9; return bytes[0]; };`)
+
+	jsContext := environment.NewContext(nil, nil, nil)
+
+	earlyBind, err := jsContext.NewEarlyBind("counter", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, _, err := earlyBind.Unbind()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exports := value.(map[string]any)
+	increment := exports["increment"].(commonjs.ProxyFunc)
+	mutate := exports["mutate"].(commonjs.ProxyFunc)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := increment(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count, err := increment(); (err != nil) || (count != int64(11)) {
+		t.Errorf("unexpected count: %v %v", count, err)
+	}
+
+	object := map[string]any{"value": "original"}
+	if result, err := mutate(object); err == nil {
+		if (object["value"] != "original") || (result.(map[string]any)["value"] != "changed") {
+			t.Errorf("argument was not cloned: %v %v", object, result)
+		}
+	} else {
+		t.Error(err)
+	}
+
+	if _, err := mutate(environment.Runtime.NewObject()); err == nil {
+		t.Error("expected an error for a runtime value")
+	}
+
+	// Typed arrays are copied
+	if bytes, ok := exports["bytes"].([]byte); ok {
+		bytes[0] = 9
+		if firstByte, err := exports["firstByte"].(commonjs.ProxyFunc)(); (err != nil) || (firstByte != int64(1)) {
+			t.Errorf("typed array was not copied: %v %v", firstByte, err)
+		}
+	} else {
+		t.Errorf("not bytes: %T", exports["bytes"])
+	}
+
+	// Typed array arguments are copied
+	bytes := []byte{1, 2, 3}
+	if firstByte, err := exports["fill"].(commonjs.ProxyFunc)(bytes); (err != nil) || (firstByte != int64(9)) {
+		t.Errorf("unexpected first byte: %v %v", firstByte, err)
+	}
+	if bytes[0] != 1 {
+		t.Error("typed array argument was not copied")
+	}
+
+	// Can be called from a task of another environment
+	if err := environment.Execute(func() error {
+		if err := environment.Runtime.Set("increment", increment); err != nil {
+			return err
+		}
+		if count, err := environment.Runtime.RunString("increment()"); err == nil {
+			if count.Export() != int64(12) {
+				t.Errorf("unexpected count: %v", count)
+			}
+			return nil
+		} else {
+			return err
+		}
+	}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPooledBind(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	var lock sync.Mutex
+	var loads, active, maxActive int
+	release := make(chan struct{})
+
+	environment.Extensions = []commonjs.Extension{{
+		Name: "pool",
+		Create: func(jsContext *commonjs.Context) any {
+			return map[string]any{
+				// The first and third loads fail
+				"shouldFail": func() bool {
+					lock.Lock()
+					defer lock.Unlock()
+					loads++
+					return (loads == 1) || (loads == 3)
+				},
+
+				"block": func() {
+					lock.Lock()
+					active++
+					maxActive = max(maxActive, active)
+					lock.Unlock()
+
+					<-release
+
+					lock.Lock()
+					active--
+					lock.Unlock()
+				},
+			}
+		},
+	}}
+
+	environment.DefineModuleSource("pooled", "if (pool.shouldFail()) throw new Error('failed');\nexports.block = function() { pool.block(); };")
+
+	jsContext := environment.NewContext(nil, nil, nil)
+
+	pooledBind, err := jsContext.NewPooledBind("pooled", "block", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Errors are not cached
+	if _, _, err := pooledBind.Unbind(); err == nil {
+		t.Fatal("expected an error")
+	}
+	value, _, err := pooledBind.Unbind()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := value.(commonjs.ProxyFunc)
+
+	activeCount := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return active
+	}
+
+	waitForActive := func(count int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for activeCount() != count {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d active calls, got %d", count, activeCount())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	var wg sync.WaitGroup
+	call := func() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := block(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	// Occupy the first member, so that the next call has to create one (which
+	// fails)
+	call()
+	waitForActive(1)
+	if _, err := block(); err == nil {
+		t.Error("expected an error")
+	}
+
+	// The failed member's slot can be used again, concurrently
+	call()
+	waitForActive(2)
+
+	// And more calls wait for an idle member
+	call()
+	time.Sleep(50 * time.Millisecond)
+
+	lock.Lock()
+	if maxActive != 2 {
+		t.Errorf("expected 2 concurrent calls, got %d", maxActive)
+	}
+	lock.Unlock()
+
+	close(release)
+	wg.Wait()
+
+	// Failed members are not kept as children
+	if children := len(jsContext.Module.Children); children != 2 {
+		t.Errorf("expected 2 children, got %d", children)
+	}
+
+	// Waiting times out
+	environment = commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	environment.Timeout = 100 * time.Millisecond
+	started := make(chan struct{})
+	release2 := make(chan struct{})
+	environment.Extensions = commonjs.NewExtensions(map[string]commonjs.CreateExtensionFunc{
+		"block": func(jsContext *commonjs.Context) any {
+			return func() {
+				close(started)
+				<-release2
+			}
+		},
+	})
+	environment.DefineModuleSource("pooled", "exports.block = block;")
+
+	jsContext = environment.NewContext(nil, nil, nil)
+
+	pooledBind, err = jsContext.NewPooledBind("pooled", "block", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _, err = pooledBind.Unbind(); err != nil {
+		t.Fatal(err)
+	}
+	block = value.(commonjs.ProxyFunc)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		block()
+	}()
+	<-started
+
+	if _, err := block(); !errors.Is(err, contextpkg.DeadlineExceeded) {
+		t.Errorf("expected a timeout: %v", err)
+	}
+
+	close(release2)
+	wg.Wait()
+}
+
+func TestPooledBindClose(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	pings := make(chan any, 10)
+	environment.Extensions = append(api.DefaultExtensions{}.Create(), commonjs.Extension{
+		Name: "pinged",
+		Create: func(jsContext *commonjs.Context) any {
+			return func(value any) {
+				pings <- value
+			}
+		},
+	})
+
+	// Subscriptions end when the member's environment is released
+	environment.DefineModuleSource("pooled", "state.namespace('pool').subscribe('ping', event => pinged(event.value));\nexports.call = () => true;")
+
+	sharedState := api.GetSharedState(environment, "pool")
+	jsContext := environment.NewContext(nil, nil, nil)
+
+	for _, close_ := range []func(pooledBind *commonjs.PooledBind){
+		func(pooledBind *commonjs.PooledBind) {
+			pooledBind.Close()
+			pooledBind.Close()
+		},
+		func(pooledBind *commonjs.PooledBind) {
+			if err := environment.Release(); err != nil {
+				t.Fatal(err)
+			}
+		},
+	} {
+		pooledBind, err := jsContext.NewPooledBind("pooled", "call", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, _, err := pooledBind.Unbind()
+		if err != nil {
+			t.Fatal(err)
+		}
+		call := value.(commonjs.ProxyFunc)
+
+		if _, err := call(); err != nil {
+			t.Fatal(err)
+		}
+		if err := sharedState.SetGo("ping", "before"); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case ping := <-pings:
+			if ping != "before" {
+				t.Errorf("unexpected ping: %v", ping)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("member was not pinged")
+		}
+
+		close_(pooledBind)
+
+		if _, err := call(); err == nil {
+			t.Error("expected an error after close")
+		}
+		if err := sharedState.SetGo("ping", "after"); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case ping := <-pings:
+			t.Errorf("member was not released: %v", ping)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package commonjs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tliron/exturl"
+)
+
+// Bundles the module at id and everything it statically requires (see
+// [Environment.Analyze]) into a single self-contained script.
+//
+// The script contains a table of all the modules, each in a function with the
+// standard module wrapper parameters, and a small runtime that provides
+// "require" for them. It evaluates to the exports of the entry module.
+//
+// When the bundle is itself required by this library it also assigns the
+// entry module's exports to "module.exports", and extensions are visible to
+// all bundled modules through the bundle's own wrapper. Note that this means
+// that per-module extension state (e.g. the module ID in "console") is that
+// of the bundle. Requires that cannot be found in the bundle (e.g. dynamic
+// requires, native modules, and virtual modules with ready-made exports) are
+// delegated to the host "require", if there is one.
+//
+// Also note that every bundled module thus gets the bundle's extensions and
+// native modules, bypassing its own selection (see
+// [Environment.SelectExtensions]).
+//
+// When the bundle is run as a plain script, extensions would have to be
+// available as globals.
+//
+// Returns an error if any of the modules cannot be read or parsed, or if any
+// static require cannot be resolved.
+func (self *Environment) Bundle(id string, bareId bool) (string, error) {
+	if analysis, err := self.Analyze(id, bareId); err == nil {
+		return self.bundle(analysis)
+	} else {
+		return "", err
+	}
+}
+
+// Like [Environment.Bundle] but starts from an already resolved URL.
+func (self *Environment) BundleURL(url exturl.URL) (string, error) {
+	return self.bundle(self.AnalyzeURL(url))
+}
+
+func (self *Environment) bundle(analysis *Analysis) (string, error) {
+	if err := analysis.Error(); err != nil {
+		return "", err
+	}
+
+	context, cancelContext := self.NewTimeoutContext()
+	defer cancelContext()
+
+	var builder strings.Builder
+
+	builder.WriteString("// Bundled by commonjs-goja from: ")
+	builder.WriteString(analysis.Entry.Id)
+	builder.WriteString("\n(function() {\nvar bundleModules = {\n")
+
+	index := 0
+	for _, module := range analysis.Modules {
+		if isSourceless(module.URL) {
+			// Left to the host "require"
+			continue
+		}
+
+		script, err := self.newAnalysisContext(module.URL).Source(context)
+		if err != nil {
+			return "", err
+		}
+
+		dependencies := make(map[string]string)
+		for _, require := range module.Requires {
+			if (require.URL != nil) && !isSourceless(require.URL) {
+				dependencies[require.Id] = require.URL.Key()
+			}
+		}
+
+		var filename, dirname string
+		if fileUrl, ok := module.URL.(*exturl.FileURL); ok {
+			filename = fileUrl.Path
+			if baseUrl, ok := fileUrl.Base().(*exturl.FileURL); ok {
+				dirname = baseUrl.Path
+			}
+		}
+
+		if index > 0 {
+			builder.WriteString(",\n")
+		}
+		builder.WriteString(toJavaScriptLiteral(module.Id))
+		builder.WriteString(": {\nfilename: ")
+		builder.WriteString(toJavaScriptLiteral(filename))
+		builder.WriteString(",\ndirname: ")
+		builder.WriteString(toJavaScriptLiteral(dirname))
+		builder.WriteString(",\ndependencies: ")
+		builder.WriteString(toJavaScriptLiteral(dependencies))
+		builder.WriteString(",\nfactory: function(" + moduleWrapperParameters + ") {\n")
+		if self.Strict {
+			builder.WriteString("'use strict';\n")
+		}
+		builder.WriteString(script)
+		builder.WriteString("\n}}")
+		index++
+	}
+
+	builder.WriteString("\n};\n")
+	builder.WriteString(bundleRuntime)
+	builder.WriteString("return bundleMain(")
+	builder.WriteString(toJavaScriptLiteral(analysis.Entry.Id))
+	builder.WriteString(");\n})();\n")
+
+	return builder.String(), nil
+}
+
+// Returns an error describing all failed modules and unresolved requires, or
+// nil if there are none.
+func (self *Analysis) Error() error {
+	var problems []string
+	for _, module := range self.Failed() {
+		problems = append(problems, fmt.Sprintf("%s: %s", module.Id, module.Err.Error()))
+	}
+	for _, require := range self.Unresolved() {
+		problems = append(problems, require.String())
+	}
+
+	switch len(problems) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("analysis failed: %s", problems[0])
+	default:
+		return fmt.Errorf("analysis failed with %d problems:\n%s", len(problems), strings.Join(problems, "\n"))
+	}
+}
+
+func toJavaScriptLiteral(value any) string {
+	// JSON is valid JavaScript (Go's encoder escapes U+2028 and U+2029)
+	bytes, _ := json.Marshal(value)
+	return string(bytes)
+}
+
+const bundleRuntime = `var bundleRequire = (typeof require === 'function') ? require : null;
+var bundleCache = {};
+
+function bundleLoad(key) {
+	var module = bundleCache[key];
+	if (module) {
+		return module.exports;
+	}
+
+	var definition = bundleModules[key];
+	module = {id: key, filename: definition.filename, path: definition.dirname, exports: {}, loaded: false, children: []};
+	bundleCache[key] = module;
+
+	var moduleRequire = function(id) {
+		if (Object.prototype.hasOwnProperty.call(definition.dependencies, id)) {
+			var dependency = definition.dependencies[id];
+			var exports = bundleLoad(dependency);
+			var child = bundleCache[dependency];
+			if (module.children.indexOf(child) === -1) {
+				module.children.push(child);
+			}
+			return exports;
+		} else if (bundleRequire !== null) {
+			return bundleRequire(id);
+		} else {
+			throw new Error('Cannot find module \'' + id + '\'');
+		}
+	};
+	moduleRequire.cache = bundleCache;
+
+	var loaded = false;
+	try {
+		definition.factory.call(module.exports, module.exports, moduleRequire, module, definition.filename, definition.dirname);
+		loaded = true;
+	} finally {
+		if (!loaded) {
+			// Don't leave half-built exports for the next require
+			delete bundleCache[key];
+		}
+	}
+	module.loaded = true;
+	return module.exports;
+}
+
+function bundleMain(key) {
+	var exports = bundleLoad(key);
+	if ((typeof module === 'object') && (module !== null)) {
+		module.exports = exports;
+	}
+	return exports;
+}
+`
@@ -0,0 +1,87 @@
+package commonjs_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/dop251/goja"
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/commonjs-goja/api"
+	"github.com/tliron/exturl"
+)
+
+func TestBundle(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	path := filepath.Join(getRoot(t), "examples")
+
+	environment := commonjs.NewEnvironment(urlContext, urlContext.NewFileURL(path))
+	defer environment.Release()
+
+	environment.Extensions = api.DefaultExtensions{}.Create()
+
+	// Run in this library, with extensions
+	if bundle, err := environment.Bundle("./start", false); err == nil {
+		exturl.UpdateInternalURL("/commonjs-test/bundle/start.js", bundle)
+		if _, err := environment.RequireURL(urlContext.NewInternalURL("/commonjs-test/bundle/start.js"), nil); err != nil {
+			t.Errorf("%s\n%s", err, bundle)
+		}
+	} else {
+		t.Fatal(err)
+	}
+
+	// Run as a plain script
+	exturl.UpdateInternalURL("/commonjs-test/bundle/plain/a.js", "const b = require('./b');\nexports.value = b.value + 1;")
+	exturl.UpdateInternalURL("/commonjs-test/bundle/plain/b.js", "exports.value = 1;")
+
+	if bundle, err := environment.BundleURL(urlContext.NewInternalURL("/commonjs-test/bundle/plain/a.js")); err == nil {
+		runtime := goja.New()
+		if value, err := runtime.RunString(bundle); err == nil {
+			if value := value.ToObject(runtime).Get("value").ToInteger(); value != 2 {
+				t.Errorf("expected 2, got %d", value)
+			}
+		} else {
+			t.Errorf("%s\n%s", err, bundle)
+		}
+	} else {
+		t.Fatal(err)
+	}
+
+	// A failed module is not cached, and children are not duplicated
+	exturl.UpdateInternalURL("/commonjs-test/bundle/retry/a.js", `
+try { require('./c'); } catch (e) {}
+require('./b');
+require('./b');
+exports.value = require('./c').value;
+exports.children = module.children.length;`)
+	exturl.UpdateInternalURL("/commonjs-test/bundle/retry/b.js", "exports.value = 1;")
+	exturl.UpdateInternalURL("/commonjs-test/bundle/retry/c.js", `
+if (!globalThis.failedOnce) {
+	globalThis.failedOnce = true;
+	throw new Error('first time');
+}
+exports.value = 3;`)
+
+	if bundle, err := environment.BundleURL(urlContext.NewInternalURL("/commonjs-test/bundle/retry/a.js")); err == nil {
+		runtime := goja.New()
+		if value, err := runtime.RunString(bundle); err == nil {
+			exports := value.ToObject(runtime)
+			if value := exports.Get("value").ToInteger(); value != 3 {
+				t.Errorf("expected 3, got %d", value)
+			}
+			if children := exports.Get("children").ToInteger(); children != 2 {
+				t.Errorf("expected 2 children, got %d", children)
+			}
+		} else {
+			t.Errorf("%s\n%s", err, bundle)
+		}
+	} else {
+		t.Fatal(err)
+	}
+
+	exturl.UpdateInternalURL("/commonjs-test/bundle/broken.js", "require('./missing');")
+	if _, err := environment.BundleURL(urlContext.NewInternalURL("/commonjs-test/bundle/broken.js")); err == nil {
+		t.Error("expected an error for an unresolved require")
+	}
+}
@@ -0,0 +1,329 @@
+package commonjs
+
+import (
+	contextpkg "context"
+	"errors"
+	"reflect"
+	"runtime"
+	"sync"
+
+	"github.com/dop251/goja"
+)
+
+var (
+	ErrChannelClosed = errors.New("channel is closed")
+	ErrNegativeSize  = errors.New("size is negative")
+	ErrNegativeCount = errors.New("wait group count is negative")
+	ErrNotAcquired   = errors.New("semaphore released more than acquired")
+)
+
+//
+// Channel
+//
+
+// A Go channel for values that can be sent and received from any runtime, as
+// well as from Go. Values are copied via a [TransferRuntime].
+//
+// Safe to share between goroutines and environments.
+type Channel struct {
+	channel  chan goja.Value // in the transfer runtime
+	transfer *TransferRuntime
+}
+
+// Returns [ErrNegativeSize] if capacity is negative.
+func NewChannel(capacity int) (*Channel, error) {
+	if capacity < 0 {
+		return nil, ErrNegativeSize
+	}
+
+	return &Channel{
+		channel:  make(chan goja.Value, capacity),
+		transfer: NewTransferRuntime(),
+	}, nil
+}
+
+// Blocks until the value is sent or the context is done. Returns
+// [ErrChannelClosed] if the channel is closed. No one else may be using the
+// from runtime during the call.
+func (self *Channel) Send(context contextpkg.Context, from *goja.Runtime, value goja.Value) error {
+	if value_, err := self.transfer.CloneIn(from, value); err == nil {
+		return self.send(context, value_)
+	} else {
+		return err
+	}
+}
+
+// Like [Channel.Send] but for a Go value.
+func (self *Channel) SendGo(context contextpkg.Context, value any) error {
+	if value_, err := self.transfer.FromGo(value); err == nil {
+		return self.send(context, value_)
+	} else {
+		return err
+	}
+}
+
+// Blocks until a value is received or the context is done. ok is false if the
+// channel is closed and empty. No one else may be using the to runtime during
+// the call.
+func (self *Channel) Receive(context contextpkg.Context, to *goja.Runtime) (goja.Value, bool, error) {
+	select {
+	case value, ok := <-self.channel:
+		if ok {
+			value_, err := self.transfer.CloneOut(to, value)
+			return value_, true, err
+		} else {
+			return goja.Undefined(), false, nil
+		}
+
+	case <-context.Done():
+		return nil, false, context.Err()
+	}
+}
+
+// Like [Channel.Receive] but for a Go value.
+func (self *Channel) ReceiveGo(context contextpkg.Context) (any, bool, error) {
+	select {
+	case value, ok := <-self.channel:
+		if ok {
+			return self.transfer.ToGo(value), true, nil
+		} else {
+			return nil, false, nil
+		}
+
+	case <-context.Done():
+		return nil, false, context.Err()
+	}
+}
+
+// Receivers will get the values that were already sent, after which they will
+// get ok as false. Returns [ErrChannelClosed] if already closed.
+func (self *Channel) Close() (err error) {
+	defer func() {
+		if err_ := handleClosedChannelPanic(recover()); err_ != nil {
+			err = err_
+		}
+	}()
+
+	close(self.channel)
+	return nil
+}
+
+// The number of values waiting to be received.
+func (self *Channel) Len() int {
+	return len(self.channel)
+}
+
+func (self *Channel) send(context contextpkg.Context, value goja.Value) (err error) {
+	defer func() {
+		if err_ := handleClosedChannelPanic(recover()); err_ != nil {
+			err = err_
+		}
+	}()
+
+	select {
+	case self.channel <- value:
+		return nil
+
+	case <-context.Done():
+		return context.Err()
+	}
+}
+
+// Call with a recover() value. Sending on or closing a closed channel panics,
+// in which case returns [ErrChannelClosed]. Other values are re-panicked.
+func handleClosedChannelPanic(r any) error {
+	if r == nil {
+		return nil
+	}
+
+	if err, ok := r.(runtime.Error); ok {
+		switch err.Error() {
+		case "send on closed channel", "close of closed channel":
+			return ErrChannelClosed
+		}
+	}
+
+	panic(r)
+}
+
+//
+// SelectCase
+//
+
+type SelectCase struct {
+	Channel *Channel
+	Send    bool
+	Value   goja.Value // for Send
+}
+
+// Blocks until one of the cases can proceed or the context is done, in the
+// manner of Go's select statement. Returns the index of the case that
+// proceeded. For receive cases also returns the value received and whether
+// the channel is open (see [Channel.Receive]). No one else may be using the
+// runtime during the call.
+func Select(context contextpkg.Context, runtime *goja.Runtime, cases []SelectCase) (index int, value goja.Value, ok bool, err error) {
+	defer func() {
+		if err_ := handleClosedChannelPanic(recover()); err_ != nil {
+			err = err_
+		}
+	}()
+
+	cases_ := make([]reflect.SelectCase, len(cases)+1)
+	for index_, case_ := range cases {
+		if case_.Send {
+			if value_, err := case_.Channel.transfer.CloneIn(runtime, case_.Value); err == nil {
+				cases_[index_] = reflect.SelectCase{
+					Dir:  reflect.SelectSend,
+					Chan: reflect.ValueOf(case_.Channel.channel),
+					Send: reflect.ValueOf(value_),
+				}
+			} else {
+				return -1, nil, false, err
+			}
+		} else {
+			cases_[index_] = reflect.SelectCase{
+				Dir:  reflect.SelectRecv,
+				Chan: reflect.ValueOf(case_.Channel.channel),
+			}
+		}
+	}
+
+	// Last case is the context
+	cases_[len(cases)] = reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(context.Done()),
+	}
+
+	index, value_, ok := reflect.Select(cases_) // can panic
+	if index == len(cases) {
+		return -1, nil, false, context.Err()
+	}
+
+	if cases[index].Send {
+		return index, nil, false, nil
+	}
+
+	if ok {
+		value, err = cases[index].Channel.transfer.CloneOut(runtime, value_.Interface().(goja.Value))
+		return index, value, true, err
+	} else {
+		return index, goja.Undefined(), false, nil
+	}
+}
+
+//
+// WaitGroup
+//
+
+// Like [sync.WaitGroup] but waiting can be cancelled.
+//
+// Safe to share between goroutines and environments.
+type WaitGroup struct {
+	count int
+	zero  chan struct{} // closed when count returns to zero
+	lock  sync.Mutex
+}
+
+func NewWaitGroup() *WaitGroup {
+	return new(WaitGroup)
+}
+
+// Returns [ErrNegativeCount] (and leaves the count as is) if the count would
+// become negative.
+func (self *WaitGroup) Add(delta int) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	count := self.count + delta
+	if count < 0 {
+		return ErrNegativeCount
+	}
+
+	if (self.count == 0) && (count > 0) {
+		self.zero = make(chan struct{})
+	} else if (self.count > 0) && (count == 0) {
+		close(self.zero)
+		self.zero = nil
+	}
+
+	self.count = count
+	return nil
+}
+
+func (self *WaitGroup) Done() error {
+	return self.Add(-1)
+}
+
+// Blocks until the count is zero or the context is done.
+func (self *WaitGroup) Wait(context contextpkg.Context) error {
+	self.lock.Lock()
+	zero := self.zero
+	self.lock.Unlock()
+
+	if zero == nil {
+		return nil
+	}
+
+	select {
+	case <-zero:
+		return nil
+
+	case <-context.Done():
+		return context.Err()
+	}
+}
+
+//
+// Semaphore
+//
+
+// A counting semaphore.
+//
+// Safe to share between goroutines and environments.
+type Semaphore struct {
+	slots chan struct{}
+}
+
+// Returns [ErrNegativeSize] if size is negative. Note that a size of 0 can
+// never be acquired.
+func NewSemaphore(size int) (*Semaphore, error) {
+	if size < 0 {
+		return nil, ErrNegativeSize
+	}
+
+	return &Semaphore{
+		slots: make(chan struct{}, size),
+	}, nil
+}
+
+// Blocks until acquired or the context is done.
+func (self *Semaphore) Acquire(context contextpkg.Context) error {
+	select {
+	case self.slots <- struct{}{}:
+		return nil
+
+	case <-context.Done():
+		return context.Err()
+	}
+}
+
+// Returns false if it cannot be acquired immediately.
+func (self *Semaphore) TryAcquire() bool {
+	select {
+	case self.slots <- struct{}{}:
+		return true
+
+	default:
+		return false
+	}
+}
+
+func (self *Semaphore) Release() error {
+	select {
+	case <-self.slots:
+		return nil
+
+	default:
+		return ErrNotAcquired
+	}
+}
@@ -0,0 +1,167 @@
+package commonjs_test
+
+import (
+	"testing"
+
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/commonjs-goja/api"
+	"github.com/tliron/exturl"
+)
+
+func TestConcurrency(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string // must set "ok"
+	}{
+		{
+			name: "workers",
+			source: `
+const jobs = concurrent.channel('jobs', 10);
+const workers = concurrent.waitGroup('workers');
+workers.add(2);
+const consumers = [new Worker('consumer'), new Worker('consumer')];
+for (let n = 1; n <= 10; n++)
+	jobs.send({n: n});
+jobs.close();
+ok = workers.wait(5) && (concurrent.atomic('total').load() === 55);
+consumers.forEach(consumer => consumer.terminate());`,
+		},
+		{
+			name:   "select timeout",
+			source: `ok = concurrent.select([{receive: concurrent.channel('', 0)}], 0.05) === null;`,
+		},
+		{
+			name: "select send",
+			source: `
+const channel = concurrent.channel('', 1);
+const selected = concurrent.select([{receive: concurrent.channel('', 0)}, {send: channel, value: {a: [1]}}]);
+ok = (selected.index === 1) && (channel.receive().value.a[0] === 1);`,
+		},
+		{
+			name: "select closed",
+			source: `
+const channel = concurrent.channel('', 0);
+channel.close();
+try { concurrent.select([{send: channel, value: 1}]); } catch (error) { ok = error.message === 'channel is closed'; }`,
+		},
+		{
+			name: "receive closed",
+			source: `
+const channel = concurrent.channel('', 1);
+channel.send(1);
+channel.close();
+const first = channel.receive();
+const second = channel.receive();
+ok = first.ok && (first.value === 1) && !second.ok;`,
+		},
+		{
+			name: "close closed",
+			source: `
+const channel = concurrent.channel('', 0);
+channel.close();
+try { channel.close(); } catch (error) { ok = error.message === 'channel is closed'; }`,
+		},
+		{
+			name:   "negative capacity",
+			source: `try { concurrent.channel('', -1); } catch (error) { ok = error.message === 'size is negative'; }`,
+		},
+		{
+			name:   "negative named capacity",
+			source: `try { concurrent.channel('channel', -1); } catch (error) { ok = error.message === 'size is negative'; }`,
+		},
+		{
+			name:   "negative size",
+			source: `try { concurrent.semaphore('', -1); } catch (error) { ok = error.message === 'size is negative'; }`,
+		},
+		{
+			name: "semaphore",
+			source: `
+const semaphore = concurrent.semaphore('');
+const acquired = [semaphore.tryAcquire(), semaphore.tryAcquire()];
+semaphore.release();
+acquired.push(semaphore.tryAcquire());
+ok = acquired[0] && !acquired[1] && acquired[2];`,
+		},
+		{
+			name: "wait group",
+			source: `
+const waitGroup = concurrent.waitGroup('');
+waitGroup.add(1);
+const waited = waitGroup.wait(0.05);
+waitGroup.done();
+try { waitGroup.done(); } catch (error) { ok = !waited && waitGroup.wait() && (error.message === 'wait group count is negative'); }`,
+		},
+		{
+			name: "delete",
+			source: `
+const atomic = concurrent.atomic('atomic');
+atomic.add(1);
+const same = concurrent.atomic('atomic') === atomic;
+concurrent.delete('atomic');
+ok = same && (concurrent.atomic('atomic').load() === 0) && (atomic.load() === 1);`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			urlContext := exturl.NewContext()
+			defer urlContext.Release()
+
+			environment := commonjs.NewEnvironment(urlContext)
+			defer environment.Release()
+
+			environment.Extensions = api.DefaultExtensions{}.Create()
+
+			environment.DefineModuleSource("consumer", `
+const jobs = concurrent.channel('jobs', 10);
+const total = concurrent.atomic('total');
+for (;;) {
+	const job = jobs.receive(5);
+	if ((job === null) || !job.ok)
+		break;
+	total.add(job.value.n);
+}
+concurrent.waitGroup('workers').done();`)
+			environment.DefineModuleSource("main", "let ok = false;\n"+test.source+"\nexports.ok = ok;")
+
+			if exports, err := environment.Require("main", false, nil); err == nil {
+				if !exports.Get("ok").ToBoolean() {
+					t.Error("concurrent API did not behave")
+				}
+			} else {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestConcurrentRegistry(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	other := commonjs.NewEnvironment(urlContext)
+	defer other.Release()
+
+	registry := api.GetConcurrentRegistry(environment)
+
+	if api.GetConcurrentRegistry(environment.NewChild().NewChild()) != registry {
+		t.Error("registry is not shared with descendants")
+	}
+
+	if api.GetConcurrentRegistry(other) == registry {
+		t.Error("registry is shared with another root environment")
+	}
+
+	if _, err := registry.Channel("channel", -1); err == nil {
+		t.Error("expected an error for a negative capacity")
+	}
+
+	// Releasing the root drops the registry
+	if err := environment.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if api.GetConcurrentRegistry(environment) == registry {
+		t.Error("registry was not dropped")
+	}
+}
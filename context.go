@@ -2,8 +2,8 @@ package commonjs
 
 import (
 	contextpkg "context"
+	"errors"
 	"fmt"
-	"strings"
 
 	"github.com/dop251/goja"
 	"github.com/tliron/exturl"
@@ -14,16 +14,31 @@ import (
 //
 
 type Context struct {
-	Environment *Environment
-	URL         exturl.URL
-	Parent      *Context
-	UserContext any
-	Module      *Module
-	Resolve     ResolveFunc
-	Extensions  []goja.Value
+	Environment        *Environment
+	URL                exturl.URL
+	Parent             *Context
+	UserContext        any
+	Module             *Module
+	Resolve            ResolveFunc
+	Extensions         []goja.Value
+	SelectedExtensions []Extension // aligned with Extensions
+	SourceMap          []byte      // JSON; set by [Context.Source]
+
+	source        string
+	sourceRead    bool
+	extensionsErr error                   // see: Context.CreateExtension
+	nativeExports map[string]*goja.Object // see: Context.requireNative
 }
 
 func (self *Environment) NewContext(url exturl.URL, parent *Context, userContext any) *Context {
+	if !self.globalsInstalled.Load() {
+		self.InstallGlobalExtensions()
+	}
+
+	return self.newContext(url, parent, userContext)
+}
+
+func (self *Environment) newContext(url exturl.URL, parent *Context, userContext any) *Context {
 	jsContext := Context{
 		Environment: self,
 		URL:         url,
@@ -36,7 +51,7 @@ func (self *Environment) NewContext(url exturl.URL, parent *Context, userContext
 		jsContext.initialize(url)
 	} else {
 		// Temporary resolver until we initialize with a URL
-		jsContext.Resolve = self.CreateResolver(nil, &jsContext)
+		jsContext.Resolve = self.newResolver(nil, &jsContext)
 	}
 
 	// See: https://nodejs.org/api/modules.html#modules_the_module_object
@@ -45,7 +60,11 @@ func (self *Environment) NewContext(url exturl.URL, parent *Context, userContext
 	jsContext.Module.Require = jsContext.NewRequire()
 
 	if parent != nil {
+		// The parent can be shared between goroutines (e.g. by binds)
+		parent.Environment.Lock.Lock()
 		parent.Module.Children = append(parent.Module.Children, jsContext.Module)
+		parent.Environment.Lock.Unlock()
+		self.addChildToRequireTransaction(parent, jsContext.Module)
 		if userContext == nil {
 			jsContext.UserContext = parent.UserContext
 		}
@@ -73,7 +92,7 @@ func (self *Context) ResolveAndRequire(context contextpkg.Context, id string, ba
 	if url, err := self.Resolve(context, id, bareId); err == nil {
 		return self.Require(context, url, childEnvironment, userContext)
 	} else {
-		return nil, nil, err
+		return nil, nil, newRequireError(id, self, err)
 	}
 }
 
@@ -109,37 +128,86 @@ func (self *Context) RequireAndExport(context contextpkg.Context, url exturl.URL
 	}
 }
 
-func (self *Context) require(context contextpkg.Context) (*goja.Object, error) {
+func (self *Context) require(context contextpkg.Context) (exports *goja.Object, err error) {
+	if transaction := self.Environment.beginRequireTransaction(); transaction != nil {
+		if self.Parent != nil {
+			// We were added to the parent's children before the transaction began
+			self.Environment.addChildToRequireTransaction(self.Parent, self.Module)
+		}
+
+		defer func() {
+			self.Environment.endRequireTransaction(transaction, err != nil)
+		}()
+	}
+
 	key := self.URL.Key()
 
 	self.Module.IsPreloading = false
 
+	if _, ok := self.URL.(*NativeURL); ok {
+		return self.requireNative(key)
+	}
+
 	// Try cache
-	if exports, loaded := self.Environment.exportsCache.Load(key); loaded {
+	if exports, loaded := self.Environment.loadExports(key); loaded {
 		// Cache hit
-		self.Module.Loaded = true
-		return exports.(*goja.Object), nil
+		self.markLoaded()
+		return exports, nil
 	} else {
 		// Cache miss
+		generation := self.Environment.generation(key)
+		self.Environment.addToRequireTransaction(key)
+		self.Environment.addToBootstrap(key)
 		if exports, err := self.runModule(context); err == nil {
-			if exports_, loaded := self.Environment.exportsCache.LoadOrStore(key, exports); loaded {
+			if exports_, loaded := self.Environment.storeExports(key, exports, generation); loaded {
 				// Cache hit
-				self.Module.Loaded = true
-				return exports_.(*goja.Object), nil
+				self.markLoaded()
+				return exports_, nil
 			} else {
 				// Cache miss
-				self.Module.Loaded = true
 				self.Environment.AddModule(self.Module)
+				self.markLoaded()
 				return exports, nil
 			}
 		} else {
-			return nil, err
+			return nil, newRequireError(self.URL.String(), self.Parent, err)
 		}
 	}
 }
 
+// The module is one of the parent's children, so we lock the parent's
+// environment (see: Module.loadedChildren).
+func (self *Context) markLoaded() {
+	environment := self.Environment
+	if self.Parent != nil {
+		environment = self.Parent.Environment
+	}
+
+	environment.Lock.Lock()
+	self.Module.Loaded = true
+	environment.Lock.Unlock()
+}
+
 func (self *Context) runModule(context contextpkg.Context) (*goja.Object, error) {
+	if exports, ok := self.virtualExports(); ok {
+		return exports, nil
+	}
+
+	if self.extensionsErr != nil {
+		return nil, self.extensionsErr
+	}
+
 	if program, err := self.getModule(context); err == nil {
+		// Not derived from the I/O context, which has its own timeout (that also
+		// must not affect how errors are reported)
+		context = contextpkg.Background()
+		if self.Environment.ExecutionTimeout > 0 {
+			var cancelContext contextpkg.CancelFunc
+			context, cancelContext = contextpkg.WithTimeout(context, self.Environment.ExecutionTimeout)
+			defer cancelContext()
+			defer self.Environment.interruptWhenDone(context)()
+		}
+
 		if value, err := self.Environment.Runtime.RunProgram(program); err == nil {
 			if call, ok := goja.AssertFunction(value); ok {
 				// See: self.compile for arguments
@@ -156,22 +224,22 @@ func (self *Context) runModule(context contextpkg.Context) (*goja.Object, error)
 				if _, err := call(nil, arguments...); err == nil {
 					return self.Module.Exports, nil
 				} else {
-					return nil, UnwrapJavaScriptException(err)
+					return nil, newLoadError(context, ErrRuntime, UnwrapJavaScriptException(err))
 				}
 			} else {
 				// Should never happen
 				return nil, fmt.Errorf("invalid module: %v", value)
 			}
 		} else {
-			return nil, UnwrapJavaScriptException(err)
+			return nil, newLoadError(context, ErrRuntime, UnwrapJavaScriptException(err))
 		}
 	} else {
-		return nil, UnwrapJavaScriptException(err)
+		return nil, err
 	}
 }
 
 func (self *Context) getModule(context contextpkg.Context) (*goja.Program, error) {
-	key := self.URL.Key()
+	key := self.programKey()
 
 	// Try cache
 	if program, loaded := self.Environment.programCache.Load(key); loaded {
@@ -194,28 +262,16 @@ func (self *Context) getModule(context contextpkg.Context) (*goja.Program, error
 }
 
 func (self *Context) compile(context contextpkg.Context) (*goja.Program, error) {
-	if script, err := exturl.ReadString(context, self.URL); err == nil {
-		// Precompile
-		if self.Environment.Precompile != nil {
-			if script, err = self.Environment.Precompile(self.URL, script, self); err != nil {
-				return nil, err
+	if script, err := self.Source(context); err == nil {
+		if program, err := compileWrappedModule(self.URL.String(), script, self.SourceMap, self.SelectedExtensions, self.Environment.Strict); err == nil {
+			return program, nil
+		} else {
+			var syntaxError *SyntaxError
+			if errors.As(err, &syntaxError) {
+				return nil, newLoadError(context, ErrSyntax, err)
 			}
+			return nil, err
 		}
-
-		// See: https://nodejs.org/api/modules.html#modules_the_module_wrapper
-		var builder strings.Builder
-		builder.WriteString("(function(exports, require, module, __filename, __dirname")
-		for _, extension := range self.Environment.Extensions {
-			builder.WriteString(", ")
-			builder.WriteString(extension.Name)
-		}
-		builder.WriteString(") {\n")
-		builder.WriteString(script)
-		builder.WriteString("\n});")
-		script = builder.String()
-		//log.Infof("%s", script)
-
-		return goja.Compile(self.URL.String(), script, self.Environment.Strict)
 	} else {
 		return nil, err
 	}
@@ -238,6 +294,63 @@ func (self *Context) initialize(url exturl.URL) {
 		}
 	}
 
-	self.Resolve = self.Environment.CreateResolver(url, self)
+	self.Resolve = self.Environment.newResolver(url, self)
 	self.AppendExtensions()
 }
+
+// Reads the module's source and precompiles it if [Environment.Precompile] or
+// [Environment.PrecompileWithSourceMap] is set. The result, as well as its source map (if it has one), is cached in the
+// context, so that e.g. an [Environment.SelectExtensions] function can inspect
+// the source without it being read again for compilation.
+func (self *Context) Source(context contextpkg.Context) (string, error) {
+	if !self.sourceRead {
+		if source, sourceMap, err := self.Environment.readModule(context, self.URL, self); err == nil {
+			self.source = source
+			self.SourceMap = sourceMap
+			self.sourceRead = true
+		} else {
+			return "", err
+		}
+	}
+
+	return self.source, nil
+}
+
+// Reads the module's source and precompiles it if [Environment.Precompile] or
+// [Environment.PrecompileWithSourceMap] is set. Also returns the source map, if
+// there is one.
+func (self *Environment) readModule(context contextpkg.Context, url exturl.URL, jsContext *Context) (string, []byte, error) {
+	if script, err := exturl.ReadString(context, url); err == nil {
+		var sourceMap []byte
+
+		// Precompile
+		if self.PrecompileWithSourceMap != nil {
+			if script, sourceMap, err = self.PrecompileWithSourceMap(url, script, jsContext); err != nil {
+				return "", nil, err
+			}
+		} else if self.Precompile != nil {
+			if script, err = self.Precompile(url, script, jsContext); err != nil {
+				return "", nil, err
+			}
+		}
+
+		if sourceMap == nil {
+			if sourceMap, err = self.readSourceMap(context, url, script); err != nil {
+				// Source maps are only used for reporting, so we don't fail the module
+				self.Log.Errorf("could not read source map for %s: %s", url.String(), err.Error())
+			}
+		}
+
+		return script, sourceMap, nil
+	} else {
+		return "", nil, newReadError(context, err)
+	}
+}
+
+// True for virtual modules with ready-made exports and for native modules.
+func isSourceless(url exturl.URL) bool {
+	if _, ok := url.(*NativeURL); ok {
+		return true
+	}
+	return isVirtualExports(url)
+}
@@ -0,0 +1,217 @@
+package commonjs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+//
+// DependencyGraph
+//
+
+type DependencyGraph struct {
+	Nodes []*DependencyNode `json:"nodes"`
+	Edges []*DependencyEdge `json:"edges"`
+}
+
+//
+// DependencyNode
+//
+
+type DependencyNode struct {
+	Id       string `json:"id"`
+	Scheme   string `json:"scheme"`
+	Filename string `json:"filename,omitempty"`
+
+	// 1-based order in which the module finished loading, or 0 if unknown
+	// (e.g. it was loaded by another environment)
+	LoadOrder int `json:"loadOrder"`
+
+	// True if the module was loaded from a URL that is neither a local file
+	// nor internal, including archives that are themselves remote
+	Remote bool `json:"remote"`
+}
+
+//
+// DependencyEdge
+//
+
+type DependencyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Walks [Environment.Modules] and their [Module.Children] and returns the
+// graph of all successfully loaded modules. Nodes are sorted by load order.
+// Edges are in the order in which the dependencies were required.
+func (self *Environment) DependencyGraph() *DependencyGraph {
+	var modules []*Module
+	self.Lock.Lock()
+	for _, id := range self.Modules.Keys() {
+		if module, ok := self.Modules.Get(id).Export().(*Module); ok {
+			modules = append(modules, module)
+		}
+	}
+	self.Lock.Unlock()
+
+	var graph DependencyGraph
+	nodes := make(map[string]*DependencyNode)
+	edges := make(map[DependencyEdge]struct{})
+
+	var addNode func(module *Module)
+	addNode = func(module *Module) {
+		if node, ok := nodes[module.Id]; ok {
+			// Modules that were cache hits have no load order
+			if (node.LoadOrder == 0) && (module.loadOrder != 0) {
+				node.LoadOrder = module.loadOrder
+			}
+			return
+		}
+
+		node := newDependencyNode(module)
+		nodes[module.Id] = node
+		graph.Nodes = append(graph.Nodes, node)
+
+		for _, child := range module.loadedChildren() {
+			edge := DependencyEdge{From: module.Id, To: child.Id}
+			if _, ok := edges[edge]; !ok {
+				edges[edge] = struct{}{}
+				graph.Edges = append(graph.Edges, &edge)
+			}
+
+			addNode(child)
+		}
+	}
+
+	// Visit in load order so that edges are deterministic
+	slices.SortStableFunc(modules, func(a *Module, b *Module) int {
+		if a.loadOrder != b.loadOrder {
+			return a.loadOrder - b.loadOrder
+		}
+		return strings.Compare(a.Id, b.Id)
+	})
+
+	for _, module := range modules {
+		addNode(module)
+	}
+
+	slices.SortStableFunc(graph.Nodes, func(a *DependencyNode, b *DependencyNode) int {
+		// Unknown load order goes last
+		switch {
+		case a.LoadOrder == b.LoadOrder:
+			return strings.Compare(a.Id, b.Id)
+		case a.LoadOrder == 0:
+			return 1
+		case b.LoadOrder == 0:
+			return -1
+		default:
+			return a.LoadOrder - b.LoadOrder
+		}
+	})
+
+	return &graph
+}
+
+// Returns the nodes that have no incoming edges, i.e. the entry points.
+func (self *DependencyGraph) Roots() []*DependencyNode {
+	required := make(map[string]struct{})
+	for _, edge := range self.Edges {
+		required[edge.To] = struct{}{}
+	}
+
+	var roots []*DependencyNode
+	for _, node := range self.Nodes {
+		if _, ok := required[node.Id]; !ok {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+// Returns the nodes for which [DependencyNode.Remote] is true.
+func (self *DependencyGraph) Remote() []*DependencyNode {
+	var remote []*DependencyNode
+	for _, node := range self.Nodes {
+		if node.Remote {
+			remote = append(remote, node)
+		}
+	}
+	return remote
+}
+
+// Writes the graph as JSON. The indent argument can be empty for compact output.
+func (self *DependencyGraph) WriteJSON(writer io.Writer, indent string) error {
+	encoder := json.NewEncoder(writer)
+	if indent != "" {
+		encoder.SetIndent("", indent)
+	}
+	return encoder.Encode(self)
+}
+
+// Writes the graph in the Graphviz DOT language. Remote modules are drawn
+// with a dashed border.
+func (self *DependencyGraph) WriteDOT(writer io.Writer) error {
+	var builder strings.Builder
+
+	builder.WriteString("digraph modules {\n")
+	for _, node := range self.Nodes {
+		label := node.Id
+		if node.LoadOrder != 0 {
+			label = fmt.Sprintf("%d: %s", node.LoadOrder, node.Id)
+		}
+		fmt.Fprintf(&builder, "  %q [label=%q", node.Id, label)
+		if node.Remote {
+			builder.WriteString(", style=dashed")
+		}
+		builder.WriteString("];\n")
+	}
+	for _, edge := range self.Edges {
+		fmt.Fprintf(&builder, "  %q -> %q;\n", edge.From, edge.To)
+	}
+	builder.WriteString("}\n")
+
+	_, err := io.WriteString(writer, builder.String())
+	return err
+}
+
+func newDependencyNode(module *Module) *DependencyNode {
+	scheme, remote := parseModuleIdScheme(module.Id)
+	return &DependencyNode{
+		Id:        module.Id,
+		Scheme:    scheme,
+		Filename:  module.Filename,
+		LoadOrder: module.loadOrder,
+		Remote:    remote,
+	}
+}
+
+// Module IDs are [exturl.URL] keys. Archive URLs ("zip:", "tar:", "git:")
+// embed the URL of the archive, which is checked recursively.
+func parseModuleIdScheme(id string) (string, bool) {
+	colon := strings.Index(id, ":")
+	if colon == -1 {
+		// Relative file paths have no scheme
+		return "file", false
+	}
+
+	scheme := id[:colon]
+	switch scheme {
+	case "file", "internal":
+		return scheme, false
+
+	case "zip", "tar":
+		archive := id[colon+1:]
+		if bang := strings.LastIndex(archive, "!"); bang != -1 {
+			archive = archive[:bang]
+		}
+		_, remote := parseModuleIdScheme(archive)
+		return scheme, remote
+
+	default:
+		// "http:", "https:", "git:", "docker:", and unknown schemes
+		return scheme, true
+	}
+}
@@ -0,0 +1,97 @@
+package commonjs_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/commonjs-goja/api"
+	"github.com/tliron/exturl"
+)
+
+func TestDependencyGraph(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	path := filepath.Join(getRoot(t), "examples")
+
+	environment := commonjs.NewEnvironment(urlContext, urlContext.NewFileURL(path))
+	defer environment.Release()
+
+	environment.Extensions = api.DefaultExtensions{}.Create()
+
+	testEnvironment(t, environment)
+
+	graph := environment.DependencyGraph()
+	if len(graph.Nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(graph.Nodes))
+	}
+	if len(graph.Edges) != 1 {
+		t.Fatalf("expected 1 edge, got %d", len(graph.Edges))
+	}
+
+	// The dependency finishes loading before the entry point
+	if !strings.HasSuffix(graph.Nodes[0].Id, "hello.js") || (graph.Nodes[0].LoadOrder != 1) {
+		t.Errorf("unexpected first node: %+v", graph.Nodes[0])
+	}
+	if roots := graph.Roots(); (len(roots) != 1) || !strings.HasSuffix(roots[0].Id, "start.js") {
+		t.Errorf("unexpected roots: %+v", roots)
+	}
+	if len(graph.Remote()) != 0 {
+		t.Errorf("unexpected remote nodes: %+v", graph.Remote())
+	}
+
+	var buffer bytes.Buffer
+	if err := graph.WriteDOT(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buffer.String(), "->") {
+		t.Errorf("DOT output has no edges:\n%s", buffer.String())
+	}
+
+	buffer.Reset()
+	if err := graph.WriteJSON(&buffer, "  "); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDependencyGraphWhileBinding(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	environment.Extensions = api.DefaultExtensions{}.Create()
+
+	environment.DefineModuleSource("lib", "exports.value = 1;")
+	environment.DefineModuleSource("main", "exports.bind = function() { bind('lib', 'value'); };")
+
+	exports, err := environment.Require("main", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Binding adds children to the module while we walk it
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 50 {
+			if _, err := environment.GetAndCall(exports, "bind", nil); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for {
+		environment.DependencyGraph()
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
@@ -2,7 +2,9 @@ package commonjs
 
 import (
 	contextpkg "context"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dop251/goja"
@@ -18,32 +20,82 @@ const DEFAULT_TIMEOUT = time.Second * 5
 //
 
 type Environment struct {
-	Runtime        *goja.Runtime
-	URLContext     *exturl.Context
-	BasePaths      []exturl.URL
-	Extensions     []Extension
-	Modules        *goja.Object
-	Precompile     PrecompileFunc
-	CreateResolver CreateResolverFunc
-	OnFileModified OnFileModifiedFunc
-	Timeout        time.Duration
-	Strict         bool
-	Log            commonlog.Logger
-	Lock           sync.Mutex
-
-	watcher      *fswatch.Watcher
-	watcherLock  sync.Mutex
-	exportsCache sync.Map
-	programCache *sync.Map
+	Runtime          *goja.Runtime
+	URLContext       *exturl.Context
+	BasePaths        []exturl.URL
+	Extensions       []Extension
+	SelectExtensions SelectExtensionsFunc
+	NativeModules    []Extension
+	Modules          *goja.Object
+	Precompile       PrecompileFunc
+	CreateResolver   CreateResolverFunc
+	OnFileModified   OnFileModifiedFunc
+	Timeout          time.Duration // for I/O, e.g. resolving and reading modules
+	Strict           bool
+	Log              commonlog.Logger
+	Lock             sync.Mutex
+
+	// Optional. Used instead of Precompile if set
+	PrecompileWithSourceMap PrecompileWithSourceMapFunc
+
+	// If > 0, running a module's code for longer interrupts it, and the error
+	// is reported as [ErrTimeout]. 0 means no limit.
+	ExecutionTimeout time.Duration
+
+	// If true, a failed top-level require rolls back everything that was first
+	// loaded during it, so that a retry starts clean
+	TransactionalRequire bool
+
+	watcher              *fswatch.Watcher
+	ownsWatcher          bool // false if shared with the parent; see: NewChild
+	watcherLock          sync.Mutex
+	exportsCache         sync.Map
+	programCache         *sync.Map
+	virtualModules       *sync.Map
+	modifications        *sync.Map // URL key to *atomic.Int64
+	generations          *sync.Map // URL key to *atomic.Int64; see: uncacheModule
+	shared               *sync.Map // see: Shared
+	loadingExtensions    sync.Map
+	javaScriptExtensions sync.Map // extension name to *goja.Object; see: requireJavaScriptExtension
+	bootstrapKeys        []string
+	bootstrapping        int
+	bootstrapLock        sync.Mutex
+	loadCounter          atomic.Int64
+	releases             sync.Map // *func() to struct{}; see: onRelease
+
+	transaction      *requireTransaction
+	transactionLock  sync.Mutex
+	executorLock     sync.Mutex
+	globalsInstalled atomic.Bool
+	isChild          bool
 }
 
+// Transforms a module's source before it is compiled.
+//
+// If the generated code has a source map then it can end with a
+// "//# sourceMappingURL=" comment, either inline (a "data:" URL) or relative to
+// the module's URL. Positions in exceptions, [JavaScriptError] and
+// console.trace will then refer to the original source. The comment is also
+// supported for modules that are not precompiled. Alternatively, see
+// [PrecompileWithSourceMapFunc].
 type PrecompileFunc func(url exturl.URL, script string, jsContext *Context) (string, error)
 
+// Like [PrecompileFunc] but also returns the generated code's source map (as
+// JSON). If it is nil then the "//# sourceMappingURL=" comment is used, if
+// there is one.
+type PrecompileWithSourceMapFunc func(url exturl.URL, script string, jsContext *Context) (string, []byte, error)
+
 type OnFileModifiedFunc func(id string, module *Module)
 
+type cachedExports struct {
+	exports    *goja.Object
+	generation int64 // see: Environment.generation
+}
+
 func NewEnvironment(urlContext *exturl.Context, basePaths ...exturl.URL) *Environment {
 	runtime := goja.New()
 	runtime.SetFieldNameMapper(DromedaryCaseMapper)
+	InstallStructuredClone(runtime)
 
 	return &Environment{
 		Runtime:        runtime,
@@ -55,44 +107,67 @@ func NewEnvironment(urlContext *exturl.Context, basePaths ...exturl.URL) *Enviro
 		Strict:         true,
 		Log:            log,
 		programCache:   new(sync.Map),
+		virtualModules: new(sync.Map),
+		modifications:  new(sync.Map),
+		generations:    new(sync.Map),
+		shared:         new(sync.Map),
 	}
 }
 
 func (self *Environment) NewChild() *Environment {
 	environment := NewEnvironment(self.URLContext, self.BasePaths...)
 	environment.Extensions = self.Extensions
+	environment.SelectExtensions = self.SelectExtensions
+	environment.NativeModules = self.NativeModules
 	environment.Precompile = self.Precompile
+	environment.PrecompileWithSourceMap = self.PrecompileWithSourceMap
 	environment.CreateResolver = self.CreateResolver
 	environment.OnFileModified = self.OnFileModified
 	environment.Timeout = self.Timeout
+	environment.ExecutionTimeout = self.ExecutionTimeout
 	environment.Strict = self.Strict
+	environment.TransactionalRequire = self.TransactionalRequire
 	environment.Log = self.Log
+	self.watcherLock.Lock()
 	environment.watcher = self.watcher
+	self.watcherLock.Unlock()
 	environment.programCache = self.programCache
+	environment.isChild = true
+	environment.virtualModules = self.virtualModules
+	environment.modifications = self.modifications
+	environment.generations = self.generations
+	environment.shared = self.shared
 	return environment
 }
 
+// Starts watching the resolved modules that are in the local filesystem.
+// Modifications are reported to [Environment.OnFileModified] and invalidate
+// [LateBind] caches. Does nothing (other than stopping a running watcher) if
+// OnFileModified is nil.
+//
+// Child environments (see [Environment.NewChild]) share the watcher that was
+// running when they were created, but do not stop it when they are released.
 func (self *Environment) StartWatcher() error {
 	self.watcherLock.Lock()
 	defer self.watcherLock.Unlock()
 
-	if self.watcher != nil {
-		if err := self.watcher.Close(); err == nil {
-			self.watcher = nil
-		} else {
-			return err
-		}
+	if err := self.stopWatcher(); err != nil {
+		return err
 	}
 
 	if self.OnFileModified == nil {
 		return nil
 	}
 
-	var err error
-	if self.watcher, err = fswatch.NewWatcher(self.URLContext); err == nil {
+	if watcher, err := fswatch.NewWatcher(self.URLContext); err == nil {
+		self.watcher = watcher
+		self.ownsWatcher = true
+
 		self.watcher.Start(func(fileUrl *exturl.FileURL) {
-			self.Lock.Lock()
 			id := fileUrl.Key()
+			self.addModification(id)
+
+			self.Lock.Lock()
 			var module *Module
 			if module_ := self.Modules.Get(id); module_ != nil {
 				module = module_.Export().(*Module)
@@ -110,16 +185,23 @@ func (self *Environment) StopWatcher() error {
 	self.watcherLock.Lock()
 	defer self.watcherLock.Unlock()
 
+	return self.stopWatcher()
+}
+
+// Must be called while watcherLock is locked. A shared watcher is not closed.
+func (self *Environment) stopWatcher() error {
 	if self.watcher != nil {
-		if err := self.watcher.Close(); err == nil {
-			self.watcher = nil
-			return nil
-		} else {
-			return err
+		if self.ownsWatcher {
+			if err := self.watcher.Close(); err != nil {
+				return err
+			}
 		}
-	} else {
-		return nil
+
+		self.watcher = nil
+		self.ownsWatcher = false
 	}
+
+	return nil
 }
 
 func (self *Environment) Watch(path string) error {
@@ -133,26 +215,150 @@ func (self *Environment) Watch(path string) error {
 	}
 }
 
+// The number of times the watcher reported the file as modified.
+func (self *Environment) modificationCount(key string) int64 {
+	if count, ok := self.modifications.Load(key); ok {
+		return count.(*atomic.Int64).Load()
+	}
+	return 0
+}
+
+func (self *Environment) addModification(key string) {
+	count, _ := self.modifications.LoadOrStore(key, new(atomic.Int64))
+	count.(*atomic.Int64).Add(1)
+}
+
+// Incremented whenever the module is uncached, in this environment or in any
+// other that shares the program cache (see: [Environment.NewChild]).
+func (self *Environment) generation(key string) int64 {
+	if generation, ok := self.generations.Load(key); ok {
+		return generation.(*atomic.Int64).Load()
+	}
+	return 0
+}
+
+func (self *Environment) addGeneration(key string) {
+	generation, _ := self.generations.LoadOrStore(key, new(atomic.Int64))
+	generation.(*atomic.Int64).Add(1)
+}
+
+// Returns false if not cached or if the cached exports are from an older
+// generation, in which case they are also removed.
+func (self *Environment) loadExports(key string) (*goja.Object, bool) {
+	if cached, ok := self.exportsCache.Load(key); ok {
+		cached_ := cached.(cachedExports)
+		if cached_.generation == self.generation(key) {
+			return cached_.exports, true
+		}
+
+		// Stale
+		self.exportsCache.CompareAndDelete(key, cached)
+		self.Lock.Lock()
+		self.Modules.Delete(key)
+		self.Lock.Unlock()
+	}
+	return nil, false
+}
+
+// If already cached returns those exports and true.
+func (self *Environment) storeExports(key string, exports *goja.Object, generation int64) (*goja.Object, bool) {
+	if cached, loaded := self.exportsCache.LoadOrStore(key, cachedExports{exports, generation}); loaded {
+		return cached.(cachedExports).exports, true
+	} else {
+		return exports, false
+	}
+}
+
+// Terminates the environment's workers and stops the watcher. Root
+// environments also close their extensions (see [Environment.CloseExtensions])
+// and drop their shared values (see [Environment.Shared]).
 func (self *Environment) Release() error {
-	return self.StopWatcher()
+	self.releases.Range(func(key any, value any) bool {
+		(*key.(*func()))()
+		return true
+	})
+
+	err := self.StopWatcher()
+
+	if !self.isChild {
+		err = errors.Join(err, self.CloseExtensions())
+		self.shared.Clear()
+	}
+
+	return err
+}
+
+// Returns the value for the key, first storing the one returned by create if
+// there is none. Shared values are shared by a root environment and all its
+// descendants (see [Environment.NewChild]), e.g. workers and binds, and are
+// dropped when the root environment is released.
+//
+// To avoid collisions the key should be of an unexported type, as with the
+// values of a [contextpkg.Context].
+func (self *Environment) Shared(key any, create func() any) any {
+	if value, ok := self.shared.Load(key); ok {
+		return value
+	}
+	value, _ := self.shared.LoadOrStore(key, create())
+	return value
+}
+
+// Registers a function to be called by [Environment.Release]. Returns a
+// function that unregisters it.
+func (self *Environment) onRelease(release func()) func() {
+	key := &release
+	self.releases.Store(key, struct{}{})
+	return func() {
+		self.releases.Delete(key)
+	}
 }
 
 func (self *Environment) NewTimeoutContext() (contextpkg.Context, contextpkg.CancelFunc) {
 	return contextpkg.WithTimeout(contextpkg.Background(), self.Timeout)
 }
 
-func (self *Environment) Call(function any, this any, arguments ...any) (any, error) {
-	//self.Lock.Lock()
-	//defer self.Lock.Unlock()
+// Interrupts the runtime with the context's error when the context is done
+// (e.g. when [Environment.ExecutionTimeout] passes), unless the returned
+// function is called first. The error is then reported as [ErrTimeout] or [ErrInterrupted].
+func (self *Environment) interruptWhenDone(context contextpkg.Context) func() {
+	interrupted := make(chan struct{})
+	stop := contextpkg.AfterFunc(context, func() {
+		self.Runtime.Interrupt(context.Err())
+		close(interrupted)
+	})
 
-	return Call(self.Runtime, function, this, arguments...)
+	return func() {
+		if !stop() {
+			// The code might have finished before the interrupt, in which case it
+			// must not affect the next run
+			<-interrupted
+			self.Runtime.ClearInterrupt()
+		}
+	}
 }
 
-func (self *Environment) GetAndCall(object *goja.Object, name string, this any, arguments ...any) (any, error) {
-	//self.Lock.Lock()
-	//defer self.Lock.Unlock()
+// Calls via [Environment.Execute], so must not be called from within one of
+// its tasks. See [Call].
+func (self *Environment) Call(function any, this any, arguments ...any) (any, error) {
+	var value any
+	err := self.Execute(func() error {
+		var err error
+		value, err = Call(self.Runtime, function, this, arguments...)
+		return err
+	})
+	return value, err
+}
 
-	return GetAndCall(self.Runtime, object, name, this, arguments...)
+// Calls via [Environment.Execute], so must not be called from within one of
+// its tasks. See [GetAndCall].
+func (self *Environment) GetAndCall(object *goja.Object, name string, this any, arguments ...any) (any, error) {
+	var value any
+	err := self.Execute(func() error {
+		var err error
+		value, err = GetAndCall(self.Runtime, object, name, this, arguments...)
+		return err
+	})
+	return value, err
 }
 
 func (self *Environment) ClearCache() {
@@ -160,29 +366,49 @@ func (self *Environment) ClearCache() {
 		self.exportsCache.Delete(key)
 		return true
 	})
+	self.javaScriptExtensions.Range(func(key any, value any) bool {
+		self.javaScriptExtensions.Delete(key)
+		return true
+	})
 	self.programCache.Range(func(key any, value any) bool {
 		self.programCache.Delete(key)
 		return true
 	})
 	self.Modules = NewThreadSafeObject().NewDynamicObject(self.Runtime)
+	self.loadCounter.Store(0)
 }
 
+// Requires via [Environment.Execute], so must not be called from within one of
+// its tasks. See [Context.Require].
 func (self *Environment) Require(id string, bareId bool, userContext any) (*goja.Object, error) {
-	context, cancelContext := self.NewTimeoutContext()
-	defer cancelContext()
+	var exports *goja.Object
+	err := self.Execute(func() error {
+		context, cancelContext := self.NewTimeoutContext()
+		defer cancelContext()
 
-	jsContext := self.NewContext(nil, nil, userContext)
-	if url, err := jsContext.Resolve(context, id, bareId); err == nil {
-		jsContext.initialize(url)
-		return jsContext.require(context)
-	} else {
-		return nil, err
-	}
+		jsContext := self.NewContext(nil, nil, userContext)
+		if url, err := jsContext.Resolve(context, id, bareId); err == nil {
+			jsContext.initialize(url)
+			exports, err = jsContext.require(context)
+			return err
+		} else {
+			return err
+		}
+	})
+	return exports, err
 }
 
+// Requires via [Environment.Execute], so must not be called from within one of
+// its tasks. See [Context.Require].
 func (self *Environment) RequireURL(url exturl.URL, userContext any) (*goja.Object, error) {
-	context, cancelContext := self.NewTimeoutContext()
-	defer cancelContext()
+	var exports *goja.Object
+	err := self.Execute(func() error {
+		context, cancelContext := self.NewTimeoutContext()
+		defer cancelContext()
 
-	return self.NewContext(url, nil, userContext).require(context)
+		var err error
+		exports, err = self.NewContext(url, nil, userContext).require(context)
+		return err
+	})
+	return exports, err
 }
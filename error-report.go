@@ -0,0 +1,198 @@
+package commonjs
+
+import (
+	contextpkg "context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tliron/exturl"
+	"github.com/tliron/go-kutil/terminal"
+)
+
+const ERROR_REPORT_CONTEXT_LINES = 2
+
+// Returns a human-readable report for an error returned by this library. See
+// [Environment.WriteErrorReport].
+func (self *Environment) ErrorReport(err error, stylist *terminal.Stylist) string {
+	var builder strings.Builder
+	self.WriteErrorReport(&builder, err, stylist)
+	return builder.String()
+}
+
+// Writes a human-readable report for an error returned by this library,
+// intended for terminals.
+//
+// If the error has a position (a [*SyntaxError] or a [*JavaScriptError]) then
+// the module's source is read again and the lines around the position are
+// written with a caret under the column. Note that for modules transformed by
+// [Environment.Precompile] without a source map this will be the original
+// source, so the position might not match it.
+//
+// Also written are the require chain (see [RequireError]) and the JavaScript
+// stack.
+//
+// The stylist can be nil, in which case colors are not used.
+func (self *Environment) WriteErrorReport(writer io.Writer, err error, stylist *terminal.Stylist) error {
+	if stylist == nil {
+		stylist = terminal.NewStylist(false)
+	}
+
+	var jsError *JavaScriptError
+	var syntaxError *SyntaxError
+	innermostError(err, &jsError, &syntaxError)
+
+	var file string
+	var line, column int
+	var headline string
+	if syntaxError != nil {
+		headline = stylist.Error("SyntaxError") + ": " + syntaxError.Message
+		file, line, column = syntaxError.File, syntaxError.Line, syntaxError.Column
+	} else if jsError != nil {
+		if jsError.Name != "" {
+			headline = stylist.Error(jsError.Name) + ": " + jsError.Message
+		} else {
+			headline = stylist.Error(jsError.Error())
+		}
+		if frame := jsError.Frame(); frame != nil {
+			file, line, column = frame.File, frame.Line, frame.Column
+		}
+	} else {
+		headline = stylist.Error(err.Error())
+	}
+
+	if _, err := fmt.Fprintln(writer, headline); err != nil {
+		return err
+	}
+
+	if file != "" {
+		if _, err := fmt.Fprintf(writer, "  at %s\n", stylist.Path(fmt.Sprintf("%s:%d:%d", file, line, column))); err != nil {
+			return err
+		}
+
+		if err := self.writeSourceExcerpt(writer, file, line, column, stylist); err != nil {
+			return err
+		}
+	}
+
+	var requireError *RequireError
+	if errors.As(err, &requireError) {
+		if (file == "") || (requireError.Module != file) {
+			if _, err := fmt.Fprintf(writer, "  in %s\n", stylist.Path(requireError.Module)); err != nil {
+				return err
+			}
+		}
+		for _, module := range requireError.RequiredFrom {
+			if _, err := fmt.Fprintf(writer, "  required from %s\n", stylist.Path(module)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if (jsError != nil) && (len(jsError.Stack) > 0) {
+		if _, err := fmt.Fprintln(writer, stylist.Heading("Stack")); err != nil {
+			return err
+		}
+		for _, frame := range jsError.Stack {
+			if _, err := fmt.Fprintf(writer, "  at %s\n", frame.String()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Source that can't be read is silently skipped.
+func (self *Environment) writeSourceExcerpt(writer io.Writer, file string, line int, column int, stylist *terminal.Stylist) error {
+	if line < 1 {
+		return nil
+	}
+
+	context, cancelContext := self.NewTimeoutContext()
+	defer cancelContext()
+
+	var lines []string
+	if url, err := self.parseReportURL(context, file); err == nil {
+		if source, err := exturl.ReadString(context, url); err == nil {
+			lines = strings.Split(source, "\n")
+		} else {
+			return nil
+		}
+	} else {
+		return nil
+	}
+
+	if line > len(lines) {
+		return nil
+	}
+
+	first := max(line-ERROR_REPORT_CONTEXT_LINES, 1)
+	last := min(line+ERROR_REPORT_CONTEXT_LINES, len(lines))
+	width := len(fmt.Sprintf("%d", last))
+
+	for number := first; number <= last; number++ {
+		text := strings.TrimRight(lines[number-1], "\r")
+
+		marker := "  "
+		if number == line {
+			marker = stylist.Error("> ")
+		}
+
+		if _, err := fmt.Fprintf(writer, "%s%*d | %s\n", marker, width, number, text); err != nil {
+			return err
+		}
+
+		if (number == line) && (column > 0) {
+			// Keep tabs so that the caret lines up
+			var padding strings.Builder
+			for index, rune_ := range text {
+				if index >= column-1 {
+					break
+				}
+				if rune_ == '\t' {
+					padding.WriteRune('\t')
+				} else {
+					padding.WriteRune(' ')
+				}
+			}
+
+			if _, err := fmt.Fprintf(writer, "  %*s | %s%s\n", width, "", padding.String(), stylist.Error("^")); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Supports our own URL types in addition to those supported by exturl.
+func (self *Environment) parseReportURL(context contextpkg.Context, file string) (exturl.URL, error) {
+	if strings.HasPrefix(file, VIRTUAL_URL_SCHEME+":") {
+		if url, ok := self.resolveVirtualModule(file); ok {
+			return url, nil
+		}
+	}
+
+	if url, ok, err := resolveFSURL(context, file, self.BasePaths); ok {
+		return url, err
+	}
+
+	return self.URLContext.NewValidAnyOrFileURL(context, file, nil)
+}
+
+// Finds the innermost [*JavaScriptError] or [*SyntaxError], which is where the
+// failure actually happened.
+func innermostError(err error, jsError **JavaScriptError, syntaxError **SyntaxError) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		switch err_ := err.(type) {
+		case *JavaScriptError:
+			*jsError = err_
+			*syntaxError = nil
+		case *SyntaxError:
+			*syntaxError = err_
+			*jsError = nil
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package commonjs_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/exturl"
+)
+
+func TestErrorReport(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	environment.DefineModuleSource("start", "require('middle');")
+	environment.DefineModuleSource("middle", "// middle\n\n\tnull.property;\n")
+	environment.DefineModuleSource("syntax", "let a = ;")
+
+	_, err := environment.Require("start", false, nil)
+	report := environment.ErrorReport(err, nil)
+	for _, expected := range []string{
+		"TypeError: ",
+		"  at virtual:middle:3:7\n",
+		"> 3 | \tnull.property;\n",
+		"    | \t     ^\n",
+		"  required from virtual:start\n",
+		"STACK\n",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("missing %q in report:\n%s", expected, report)
+		}
+	}
+
+	_, err = environment.Require("syntax", false, nil)
+	report = environment.ErrorReport(err, nil)
+	for _, expected := range []string{
+		"SyntaxError: Unexpected token ;\n",
+		"> 1 | let a = ;\n",
+		"    |         ^\n",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("missing %q in report:\n%s", expected, report)
+		}
+	}
+}
@@ -2,32 +2,17 @@ package commonjs
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/dop251/goja"
-	"github.com/tliron/go-kutil/util"
 )
 
+// If err is a [*goja.Exception], converts it to a [*JavaScriptError].
+// Otherwise returns err as is.
 func UnwrapJavaScriptException(err error) error {
 	if exception, ok := err.(*goja.Exception); ok {
-		switch exported := exception.Value().Export().(type) {
-		case error:
-			return UnwrapJavaScriptException(exported)
-
-		case map[string]any:
-			if value, ok := exported["value"]; ok {
-				switch value_ := value.(type) {
-				case error:
-					return UnwrapJavaScriptException(value_)
-
-				default:
-					return errors.New(util.ToString(value))
-				}
-			}
-
-		default:
-			// This will work only if it's the *current* Go error in the runtime
-			return UnwrapJavaScriptException(exception)
-		}
+		return NewJavaScriptError(exception)
 	}
 
 	return err
@@ -62,3 +47,217 @@ func HandleJavaScriptPanic(r any) error {
 		panic(r)
 	}
 }
+
+//
+// JavaScriptError
+//
+
+// A structured representation of a JavaScript exception.
+//
+// If the exception wraps a Go error (e.g. one returned by a Go function called
+// from JavaScript) then it is available as Cause, which is also what Unwrap
+// returns, so [errors.Is] and [errors.As] can reach it. In that case Error
+// returns the cause's message, as it did before this type was introduced.
+type JavaScriptError struct {
+	Name       string         // e.g. "TypeError", or empty if a non-object was thrown
+	Message    string         // or the string representation of a non-object that was thrown
+	Stack      []StackFrame   // innermost first
+	Properties map[string]any // other own properties of the thrown object, e.g. "code"
+	Cause      error
+	Exception  *goja.Exception
+}
+
+func NewJavaScriptError(exception *goja.Exception) *JavaScriptError {
+	self := JavaScriptError{
+		Exception: exception,
+	}
+
+	for _, frame := range exception.Stack() {
+		position := frame.Position()
+		self.Stack = append(self.Stack, StackFrame{
+			File:     position.Filename,
+			Line:     position.Line,
+			Column:   position.Column,
+			Function: frame.FuncName(),
+			Native:   frame.SrcName() == "<native>",
+		})
+	}
+
+	value := exception.Value()
+	if object, ok := value.(*goja.Object); ok {
+		self.Name = getString(object, "name")
+		self.Message = getString(object, "message")
+
+		for _, key := range object.Keys() {
+			switch key {
+			case "name", "message", "stack", "value":
+			default:
+				if self.Properties == nil {
+					self.Properties = make(map[string]any)
+				}
+				self.Properties[key] = object.Get(key).Export()
+			}
+		}
+	} else if value != nil {
+		self.Message = value.String()
+	}
+
+	if cause := exception.Unwrap(); cause != nil {
+		self.Cause = UnwrapJavaScriptException(cause)
+	}
+
+	return &self
+}
+
+// ([error] interface)
+func (self *JavaScriptError) Error() string {
+	if self.Cause != nil {
+		return self.Cause.Error()
+	}
+
+	var builder strings.Builder
+	if self.Name != "" {
+		builder.WriteString(self.Name)
+		builder.WriteString(": ")
+	}
+	builder.WriteString(self.Message)
+	if frame := self.Frame(); frame != nil {
+		builder.WriteString(" at ")
+		builder.WriteString(frame.String())
+	}
+	return builder.String()
+}
+
+// Support for [errors.Unwrap].
+func (self *JavaScriptError) Unwrap() error {
+	return self.Cause
+}
+
+// The innermost non-native frame, or nil if there is none.
+func (self *JavaScriptError) Frame() *StackFrame {
+	for index := range self.Stack {
+		if !self.Stack[index].Native {
+			return &self.Stack[index]
+		}
+	}
+	return nil
+}
+
+// The full stack, one frame per line, in the style of JavaScript's
+// "Error.prototype.stack".
+func (self *JavaScriptError) StackString() string {
+	var builder strings.Builder
+	if self.Name != "" {
+		builder.WriteString(self.Name)
+		builder.WriteString(": ")
+	}
+	builder.WriteString(self.Message)
+	for _, frame := range self.Stack {
+		builder.WriteString("\n    at ")
+		builder.WriteString(frame.String())
+	}
+	return builder.String()
+}
+
+//
+// RequireError
+//
+
+// An error loading a module, together with the chain of modules that led to
+// it.
+//
+// The error string looks like: "/x.js required from /y.js required from
+// /start.js: <cause>".
+type RequireError struct {
+	// The failed module's URL, or the ID as it was passed to "require" if it
+	// could not be resolved
+	Module string
+
+	// The modules that required it, the nearest first and the entry point last
+	RequiredFrom []string
+
+	Err error
+}
+
+// Wraps err in a [*RequireError]. If err already contains a [*RequireError]
+// then it is returned as is, because the innermost failure has the most
+// complete chain.
+func newRequireError(module string, requiredFrom *Context, err error) error {
+	var requireError *RequireError
+	if errors.As(err, &requireError) {
+		return err
+	}
+
+	requireError = &RequireError{
+		Module: module,
+		Err:    err,
+	}
+	if requiredFrom != nil {
+		requireError.RequiredFrom = requiredFrom.RequireChain()
+	}
+	return requireError
+}
+
+// The URLs of the context's module and of the modules that required it, the
+// nearest first and the entry point last.
+func (self *Context) RequireChain() []string {
+	var chain []string
+	for jsContext := self; jsContext != nil; jsContext = jsContext.Parent {
+		if jsContext.URL != nil {
+			chain = append(chain, jsContext.URL.String())
+		}
+	}
+	return chain
+}
+
+// ([error] interface)
+func (self *RequireError) Error() string {
+	var builder strings.Builder
+	builder.WriteString(self.Module)
+	for _, module := range self.RequiredFrom {
+		builder.WriteString(" required from ")
+		builder.WriteString(module)
+	}
+	builder.WriteString(": ")
+	builder.WriteString(self.Err.Error())
+	return builder.String()
+}
+
+// Support for [errors.Unwrap].
+func (self *RequireError) Unwrap() error {
+	return self.Err
+}
+
+//
+// StackFrame
+//
+
+type StackFrame struct {
+	File     string
+	Line     int // 1-based
+	Column   int // 1-based
+	Function string
+	Native   bool
+}
+
+// ([fmt.Stringer] interface)
+func (self StackFrame) String() string {
+	if self.Native {
+		return self.Function + " (native)"
+	}
+
+	location := fmt.Sprintf("%s:%d:%d", self.File, self.Line, self.Column)
+	if self.Function != "" {
+		return fmt.Sprintf("%s (%s)", self.Function, location)
+	}
+	return location
+}
+
+// Utils
+
+func getString(object *goja.Object, name string) string {
+	if value := object.Get(name); (value != nil) && !goja.IsUndefined(value) && !goja.IsNull(value) {
+		return value.String()
+	}
+	return ""
+}
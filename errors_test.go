@@ -0,0 +1,114 @@
+package commonjs_test
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/exturl"
+)
+
+var errTest = errors.New("test error")
+
+func TestJavaScriptError(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	environment.Extensions = []commonjs.Extension{{
+		Name: "fail",
+		Create: func(jsContext *commonjs.Context) any {
+			return func() error {
+				return errTest
+			}
+		},
+	}}
+
+	environment.DefineModuleSource("throws", "function thrower() {\n  const error = new TypeError('bad');\n  error.code = 'E_BAD';\n  throw error;\n}\nthrower();")
+	environment.DefineModuleSource("fails", "fail();")
+
+	_, err := environment.Require("throws", false, nil)
+	var jsError *commonjs.JavaScriptError
+	if errors.As(err, &jsError) {
+		if (jsError.Name != "TypeError") || (jsError.Message != "bad") {
+			t.Errorf("unexpected name and message: %s", jsError.Error())
+		}
+		if jsError.Properties["code"] != "E_BAD" {
+			t.Errorf("unexpected properties: %v", jsError.Properties)
+		}
+		if frame := jsError.Frame(); (frame == nil) || (frame.Function != "thrower") || (frame.File != "virtual:throws") {
+			t.Errorf("unexpected frame: %v", frame)
+		}
+	} else {
+		t.Fatalf("not a JavaScriptError: %T %v", err, err)
+	}
+
+	_, err = environment.Require("fails", false, nil)
+	if !errors.Is(err, errTest) {
+		t.Errorf("cause not found: %T %v", err, err)
+	}
+	if !errors.As(err, &jsError) {
+		t.Errorf("not a JavaScriptError: %T %v", err, err)
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	environment.DefineModuleSource("first", "throw new Error('first');")
+	environment.DefineModuleSource("third", "\n\n  null.property;")
+	environment.DefineModuleSource("nested", "class A { x = (({a = null.b}) => a)({}); }; new A();")
+	environment.DefineModuleSource("syntax", "let a = ;")
+
+	for id, expected := range map[string]commonjs.StackFrame{
+		"first":  {Line: 1, Column: 7},
+		"third":  {Line: 3, Column: 8},
+		"nested": {Line: 1, Column: 27},
+	} {
+		_, err := environment.Require(id, false, nil)
+		var jsError *commonjs.JavaScriptError
+		if errors.As(err, &jsError) {
+			if frame := jsError.Frame(); (frame == nil) || (frame.Line != expected.Line) || (frame.Column != expected.Column) {
+				t.Errorf("%s: unexpected frame: %v", id, frame)
+			}
+		} else {
+			t.Errorf("%s: not a JavaScriptError: %T %v", id, err, err)
+		}
+	}
+
+	if _, err := environment.Require("syntax", false, nil); (err == nil) || !strings.Contains(err.Error(), "virtual:syntax: Line 1:9 ") {
+		t.Errorf("unexpected syntax error: %v", err)
+	}
+}
+
+func TestRequireError(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	environment.DefineModuleSource("start", "require('middle');")
+	environment.DefineModuleSource("middle", "require('missing');")
+
+	_, err := environment.Require("start", false, nil)
+	var requireError *commonjs.RequireError
+	if errors.As(err, &requireError) {
+		if (requireError.Module != "missing") || !slices.Equal(requireError.RequiredFrom, []string{"virtual:middle", "virtual:start"}) {
+			t.Errorf("unexpected chain: %s %v", requireError.Module, requireError.RequiredFrom)
+		}
+		if !strings.HasPrefix(err.Error(), "missing required from virtual:middle required from virtual:start: ") {
+			t.Errorf("unexpected error string: %s", err.Error())
+		}
+	} else {
+		t.Fatalf("not a RequireError: %T %v", err, err)
+	}
+}
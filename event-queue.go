@@ -0,0 +1,74 @@
+package commonjs
+
+import (
+	"sync"
+)
+
+//
+// eventQueue
+//
+
+// Unbounded, so that senders never block (which could deadlock two
+// environments that send to each other).
+type eventQueue[E any] struct {
+	events []E
+	closed bool
+	signal chan struct{}
+	lock   sync.Mutex
+}
+
+func newEventQueue[E any]() *eventQueue[E] {
+	return &eventQueue[E]{
+		signal: make(chan struct{}, 1),
+	}
+}
+
+func (self *eventQueue[E]) push(event E) {
+	self.lock.Lock()
+	if !self.closed {
+		self.events = append(self.events, event)
+	}
+	self.lock.Unlock()
+	self.notify()
+}
+
+func (self *eventQueue[E]) close() {
+	self.lock.Lock()
+	self.closed = true
+	self.lock.Unlock()
+	self.notify()
+}
+
+// Blocks until there is an event. Returns false if the queue is closed (and
+// empty) or if done is closed.
+func (self *eventQueue[E]) pop(done chan struct{}) (E, bool) {
+	for {
+		self.lock.Lock()
+		if len(self.events) > 0 {
+			event := self.events[0]
+			self.events = self.events[1:]
+			self.lock.Unlock()
+			return event, true
+		}
+		closed := self.closed
+		self.lock.Unlock()
+
+		var none E
+		if closed {
+			return none, false
+		}
+
+		select {
+		case <-self.signal:
+		case <-done:
+			return none, false
+		}
+	}
+}
+
+func (self *eventQueue[E]) notify() {
+	select {
+	case self.signal <- struct{}{}:
+	default:
+	}
+}
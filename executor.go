@@ -0,0 +1,21 @@
+package commonjs
+
+// Runs the task while no other task is running in the environment's runtime.
+// A [goja.Runtime] can be used from any goroutine, but not from more than one
+// at the same time, so all access to the runtime should go through here once
+// other goroutines might use it, e.g. once there is a [Worker], a
+// [SharedState] subscription, or a bind proxy. [Environment.Require],
+// [Environment.RequireURL], [Environment.Call], and [Environment.GetAndCall]
+// already do. Returns the task's error.
+//
+// Tasks are not reentrant: a task must not call Execute for the same
+// environment, directly or indirectly (e.g. via one of the functions above or
+// via a [ProxyFunc] for the environment's values), because that would
+// deadlock. A task already has exclusive use of the runtime, so instead it can
+// use it directly, e.g. via [Context.Require], [Call], and [GetAndCall].
+func (self *Environment) Execute(task func() error) error {
+	self.executorLock.Lock()
+	defer self.executorLock.Unlock()
+
+	return task()
+}
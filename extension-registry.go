@@ -0,0 +1,182 @@
+package commonjs
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+//
+// ExtensionRegistry
+//
+
+// An ordered collection of extensions. Registration order is preserved
+// except where [Extension.Dependencies] require otherwise.
+type ExtensionRegistry struct {
+	extensions []Extension
+}
+
+func NewExtensionRegistry() *ExtensionRegistry {
+	return new(ExtensionRegistry)
+}
+
+// Returns an error if an extension's name is not a valid JavaScript identifier,
+// is reserved, or is already registered. In case of error none of the
+// extensions will be registered.
+func (self *ExtensionRegistry) Register(extensions ...Extension) error {
+	extensions_ := append(slices.Clone(self.extensions), extensions...)
+	if err := ValidateExtensions(extensions_); err == nil {
+		self.extensions = extensions_
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Returns the registered extensions sorted by dependencies. See [SortExtensions].
+func (self *ExtensionRegistry) Extensions() ([]Extension, error) {
+	return SortExtensions(self.extensions)
+}
+
+// Checks for names that are not valid JavaScript identifiers, are reserved
+// (including the module wrapper parameters), or are duplicates.
+func ValidateExtensions(extensions []Extension) error {
+	names := make(map[string]struct{})
+	for _, extension := range extensions {
+		if err := ValidateExtensionName(extension.Name); err != nil {
+			return err
+		}
+
+		if _, ok := names[extension.Name]; ok {
+			return fmt.Errorf("duplicate extension: %q", extension.Name)
+		}
+		names[extension.Name] = struct{}{}
+	}
+	return nil
+}
+
+var identifierRe = regexp.MustCompile(`^[\p{L}\p{Nl}$_][\p{L}\p{Nl}\p{Mn}\p{Mc}\p{Nd}\p{Pc}$_]*$`)
+
+var reservedNames = []string{
+	// Module wrapper parameters
+	"exports", "require", "module", "__filename", "__dirname",
+
+	// Keywords and other names that can't be parameters in strict mode
+	"arguments", "await", "break", "case", "catch", "class", "const", "continue", "debugger", "default",
+	"delete", "do", "else", "enum", "eval", "export", "extends", "false", "finally", "for", "function",
+	"if", "implements", "import", "in", "instanceof", "interface", "let", "new", "null", "package",
+	"private", "protected", "public", "return", "static", "super", "switch", "this", "throw", "true",
+	"try", "typeof", "var", "void", "while", "with", "yield",
+}
+
+// Returns an error if the name cannot be used as a module wrapper parameter.
+func ValidateExtensionName(name string) error {
+	if !identifierRe.MatchString(name) {
+		return fmt.Errorf("extension name is not a valid identifier: %q", name)
+	}
+	if slices.Contains(reservedNames, name) {
+		return fmt.Errorf("extension name is reserved: %q", name)
+	}
+	return nil
+}
+
+// Validates the extensions (see [ValidateExtensions]) and returns them in an
+// order in which every extension comes after its [Extension.Dependencies].
+// Otherwise the original order is preserved, so the result is deterministic.
+//
+// Returns an error for unknown dependencies or dependency cycles.
+func SortExtensions(extensions []Extension) ([]Extension, error) {
+	if err := ValidateExtensions(extensions); err != nil {
+		return nil, err
+	}
+
+	indexes := make(map[string]int)
+	for index, extension := range extensions {
+		indexes[extension.Name] = index
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	sorted := make([]Extension, 0, len(extensions))
+	state := make([]int, len(extensions))
+	var path []string
+
+	var visit func(index int) error
+	visit = func(index int) error {
+		extension := extensions[index]
+
+		switch state[index] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("extension dependency cycle: %s -> %s", strings.Join(path, " -> "), extension.Name)
+		}
+
+		state[index] = visiting
+		path = append(path, extension.Name)
+
+		for _, dependency := range extension.Dependencies {
+			if index_, ok := indexes[dependency]; ok {
+				if err := visit(index_); err != nil {
+					return err
+				}
+			} else {
+				return fmt.Errorf("extension %q depends on unknown extension: %q", extension.Name, dependency)
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[index] = visited
+		sorted = append(sorted, extension)
+		return nil
+	}
+
+	for index := range extensions {
+		if err := visit(index); err != nil {
+			return nil, err
+		}
+	}
+
+	return sorted, nil
+}
+
+// Returns the selected extensions together with all their transitive
+// [Extension.Dependencies], in the order of all. Unknown dependencies are
+// ignored (they are reported by [SortExtensions]).
+func includeDependencies(selected []Extension, all []Extension) []Extension {
+	included := make(map[string]struct{})
+
+	var include func(name string)
+	include = func(name string) {
+		if _, ok := included[name]; ok {
+			return
+		}
+		included[name] = struct{}{}
+
+		for _, extension := range all {
+			if extension.Name == name {
+				for _, dependency := range extension.Dependencies {
+					include(dependency)
+				}
+				break
+			}
+		}
+	}
+
+	for _, extension := range selected {
+		include(extension.Name)
+	}
+
+	var extensions []Extension
+	for _, extension := range all {
+		if _, ok := included[extension.Name]; ok {
+			extensions = append(extensions, extension)
+		}
+	}
+	return extensions
+}
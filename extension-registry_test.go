@@ -0,0 +1,136 @@
+package commonjs_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/exturl"
+)
+
+func TestExtensionRegistry(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+
+	var closed []string
+	newExtension := func(name string, value any, dependencies ...string) commonjs.Extension {
+		return commonjs.Extension{
+			Name: name,
+			Create: func(jsContext *commonjs.Context) any {
+				if len(dependencies) > 0 {
+					if dependency, ok := jsContext.GetExtension(dependencies[0]); ok {
+						return dependency.ToInteger() + 1
+					}
+				}
+				return value
+			},
+			Dependencies: dependencies,
+			Close: func(environment *commonjs.Environment) error {
+				closed = append(closed, name)
+				return nil
+			},
+		}
+	}
+
+	registry := commonjs.NewExtensionRegistry()
+	if err := registry.Register(newExtension("b", nil, "a"), newExtension("a", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register(newExtension("a", 1)); err == nil {
+		t.Error("expected an error for a duplicate")
+	}
+	if err := registry.Register(newExtension("not-valid", 1)); err == nil {
+		t.Error("expected an error for an invalid identifier")
+	}
+	if err := registry.Register(newExtension("module", 1)); err == nil {
+		t.Error("expected an error for a reserved name")
+	}
+
+	if extensions, err := registry.Extensions(); err == nil {
+		if err := environment.SetExtensions(extensions...); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		t.Fatal(err)
+	}
+
+	if (environment.Extensions[0].Name != "a") || (environment.Extensions[1].Name != "b") {
+		t.Errorf("unexpected order: %s, %s", environment.Extensions[0].Name, environment.Extensions[1].Name)
+	}
+
+	environment.DefineModuleSource("main", "exports.value = a + b;")
+	if exports, err := environment.Require("main", false, nil); err == nil {
+		if value := exports.Get("value").ToInteger(); value != 3 {
+			t.Errorf("unexpected value: %d", value)
+		}
+	} else {
+		t.Fatal(err)
+	}
+
+	if _, err := commonjs.SortExtensions([]commonjs.Extension{newExtension("a", nil, "b"), newExtension("b", nil, "a")}); err == nil {
+		t.Error("expected an error for a cycle")
+	}
+
+	// Children don't close extensions
+	environment.NewChild().Release()
+	if len(closed) != 0 {
+		t.Errorf("unexpected close: %v", closed)
+	}
+
+	environment.Release()
+	if (len(closed) != 2) || (closed[0] != "b") {
+		t.Errorf("unexpected close: %v", closed)
+	}
+}
+
+func TestJavaScriptExtension(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	environment.Extensions = []commonjs.Extension{
+		commonjs.NewJavaScriptExtension("helpers", "helpers", false),
+		commonjs.NewJavaScriptExtension("self", "self", true),
+	}
+
+	// The extension modules don't receive themselves, and neither do the
+	// modules they require, but those are not cached
+	environment.DefineModuleSource("helpers", "globalThis.helpersRuns = (globalThis.helpersRuns || 0) + 1;\nexports.bootstrapped = (typeof helpers === 'undefined') && !require('lib').hasHelpers;\nexports.double = function(value) { return value * 2; };")
+	environment.DefineModuleSource("lib", "exports.hasHelpers = (typeof helpers !== 'undefined');")
+	environment.DefineModuleSource("self", "module.exports = function(module) { return {id: module.id}; };")
+	environment.DefineModuleSource("main", "exports.value = [helpers.double(2), helpers.bootstrapped, self.id, require('lib').hasHelpers, helpersRuns].join(' ');")
+
+	if exports, err := environment.Require("main", false, nil); err == nil {
+		if value := exports.Get("value").String(); value != "4 true virtual:main true 1" {
+			t.Errorf("unexpected value: %s", value)
+		}
+	} else {
+		t.Fatal(err)
+	}
+
+	// Errors fail the require
+	environment = commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	environment.Extensions = []commonjs.Extension{
+		commonjs.NewJavaScriptExtension("missing", "missing", false),
+		commonjs.NewJavaScriptExtension("notFunction", "notFunction", true),
+	}
+
+	environment.DefineModuleSource("notFunction", "exports.value = 1;")
+	environment.DefineModuleSource("main", "exports.value = 1;")
+
+	if _, err := environment.Require("main", false, nil); err != nil {
+		for _, expected := range []string{`extension "missing"`, `extension "notFunction"`} {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("expected %s in: %s", expected, err)
+			}
+		}
+	} else {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,161 @@
+package commonjs
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Returns the extensions to use for a module. The returned extensions must be
+// a subset of the provided extensions, in the same order. Their
+// [Extension.Dependencies] are added to the selection automatically.
+//
+// Native modules (see [Environment.NativeModules] and [Extension.Native]) can
+// only be required by modules for which they are selected. Note that
+// [Extension.Global] extensions are installed for all code in the runtime
+// regardless of selection.
+type SelectExtensionsFunc func(jsContext *Context, extensions []Extension) []Extension
+
+func (self *Environment) selectExtensions(jsContext *Context) []Extension {
+	if self.SelectExtensions != nil {
+		return includeDependencies(self.SelectExtensions(jsContext, self.Extensions), self.Extensions)
+	} else {
+		return self.Extensions
+	}
+}
+
+// The program cache is keyed by the URL and the module wrapper parameters,
+// which depend on the selected extensions.
+func (self *Context) programKey() string {
+	key := self.URL.Key()
+	if self.Environment.SelectExtensions != nil {
+		names := make([]string, len(self.SelectedExtensions))
+		for index, extension := range self.SelectedExtensions {
+			names[index] = extension.Name
+		}
+		key += "|" + strings.Join(names, ",")
+	}
+	return key
+}
+
+//
+// ExtensionRule
+//
+
+type ExtensionRule struct {
+	// Matched against the module's URL key (which is also [Module.Id])
+	Pattern *regexp.Regexp
+
+	// Names of allowed extensions; nil means all
+	Extensions []string
+}
+
+// Returns a [SelectExtensionsFunc] that uses the first rule whose pattern
+// matches the module's URL. If no rule matches all extensions are allowed,
+// so a catch-all rule (e.g. with an empty pattern) can be added last.
+func NewURLExtensionSelector(rules ...ExtensionRule) SelectExtensionsFunc {
+	// SelectExtensionsFunc signature
+	return func(jsContext *Context, extensions []Extension) []Extension {
+		if jsContext.URL == nil {
+			return extensions
+		}
+
+		key := jsContext.URL.Key()
+		for _, rule := range rules {
+			if rule.Pattern.MatchString(key) {
+				if rule.Extensions == nil {
+					return extensions
+				}
+				return filterExtensions(extensions, rule.Extensions)
+			}
+		}
+
+		return extensions
+	}
+}
+
+// Returns a [SelectExtensionsFunc] that lets modules declare the extensions
+// they need in a comment at the top of their source (see
+// [ParseExtensionsMetadata]). Modules can only narrow the selection, never
+// widen it. Modules without the metadata get all extensions.
+//
+// The source is read via [Context.Source], so it is not read again for
+// compilation.
+func NewMetadataExtensionSelector() SelectExtensionsFunc {
+	// SelectExtensionsFunc signature
+	return func(jsContext *Context, extensions []Extension) []Extension {
+		if jsContext.URL == nil {
+			return extensions
+		}
+
+		// Avoid reading the source if the module won't run
+		if _, ok := jsContext.Environment.loadExports(jsContext.URL.Key()); ok {
+			return extensions
+		}
+
+		context, cancelContext := jsContext.Environment.NewTimeoutContext()
+		defer cancelContext()
+
+		if source, err := jsContext.Source(context); err == nil {
+			if names, ok := ParseExtensionsMetadata(source); ok {
+				return filterExtensions(extensions, names)
+			}
+		}
+
+		// Errors will be reported during compilation
+		return extensions
+	}
+}
+
+// Returns a [SelectExtensionsFunc] that applies the selectors in order, each
+// one narrowing the selection of the previous one. For example, URL rules
+// followed by metadata.
+func ChainExtensionSelectors(selectors ...SelectExtensionsFunc) SelectExtensionsFunc {
+	// SelectExtensionsFunc signature
+	return func(jsContext *Context, extensions []Extension) []Extension {
+		for _, select_ := range selectors {
+			extensions = select_(jsContext, extensions)
+		}
+		return extensions
+	}
+}
+
+// Parses a "// @extensions name1, name2" line comment among the comments at the
+// very top of the source (before any code). Names can be separated by commas
+// and/or spaces. A "#!" line at the very beginning is skipped.
+func ParseExtensionsMetadata(source string) ([]string, bool) {
+	for index, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+
+		if (index == 0) && strings.HasPrefix(line, "#!") {
+			continue
+		}
+
+		if line == "" {
+			continue
+		}
+
+		if comment, ok := strings.CutPrefix(line, "//"); ok {
+			if names, ok := strings.CutPrefix(strings.TrimSpace(comment), "@extensions"); ok {
+				return strings.FieldsFunc(names, func(r rune) bool {
+					return (r == ',') || (r == ' ') || (r == '\t')
+				}), true
+			}
+		} else {
+			break
+		}
+	}
+
+	return nil, false
+}
+
+// Also includes the dependencies of the named extensions.
+func filterExtensions(extensions []Extension, names []string) []Extension {
+	var filtered []Extension
+	for _, extension := range extensions {
+		if slices.Contains(names, extension.Name) {
+			filtered = append(filtered, extension)
+		}
+	}
+	return includeDependencies(filtered, extensions)
+}
@@ -0,0 +1,102 @@
+package commonjs_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/commonjs-goja/api"
+	"github.com/tliron/exturl"
+)
+
+func TestSelectExtensions(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	environment.Extensions = append(api.DefaultExtensions{}.Create(), commonjs.Extension{
+		Name:         "greeter",
+		Dependencies: []string{"util"},
+		Create: func(jsContext *commonjs.Context) any {
+			_, ok := jsContext.GetExtension("util")
+			return ok
+		},
+	})
+	environment.SelectExtensions = commonjs.ChainExtensionSelectors(
+		commonjs.NewURLExtensionSelector(commonjs.ExtensionRule{
+			Pattern:    regexp.MustCompile(`^virtual:plugin/`),
+			Extensions: []string{"console", "util", "greeter"},
+		}, commonjs.ExtensionRule{
+			Pattern:    regexp.MustCompile(`^virtual:greeter/`),
+			Extensions: []string{"greeter"},
+		}),
+		commonjs.NewMetadataExtensionSelector(),
+	)
+
+	environment.DefineModuleSource("core", "exports.value = typeof os;")
+	environment.DefineModuleSource("plugin/a", "exports.value = typeof os;")
+	environment.DefineModuleSource("plugin/b", "// @extensions util, os\nexports.value = (typeof util) + ' ' + (typeof os) + ' ' + (typeof console);")
+	environment.DefineModuleSource("greeter/a", "exports.value = greeter + ' ' + (typeof util) + ' ' + (typeof console);")
+	environment.DefineModuleSource("plugin/c", "// @extensions greeter\nexports.value = greeter + ' ' + (typeof util) + ' ' + (typeof console);")
+
+	for id, expected := range map[string]string{
+		"core":      "object",
+		"plugin/a":  "undefined",
+		"plugin/b":  "object undefined undefined",
+		"plugin/c":  "true object undefined", // the dependency is selected, too
+		"greeter/a": "true object undefined",
+	} {
+		if exports, err := environment.Require(id, false, nil); err == nil {
+			if value := exports.Get("value").String(); value != expected {
+				t.Errorf("%s: expected %q, got %q", id, expected, value)
+			}
+		} else {
+			t.Errorf("%s: %s", id, err)
+		}
+	}
+}
+
+func TestSelectNativeModules(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	environment.Extensions = api.DefaultExtensions{}.Create()
+	environment.NativeModules = []commonjs.Extension{{
+		Name:   "files",
+		Create: api.CreateOSExtension,
+	}}
+	environment.SelectExtensions = commonjs.NewURLExtensionSelector(commonjs.ExtensionRule{
+		Pattern:    regexp.MustCompile(`^virtual:plugin/`),
+		Extensions: []string{"console"},
+	})
+
+	environment.DefineModuleSource("core", "exports.value = typeof require('os') + ' ' + typeof require('files');")
+	environment.DefineModuleSource("plugin/console", "exports.value = typeof require('console');")
+	environment.DefineModuleSource("plugin/os", "require('os');")
+	environment.DefineModuleSource("plugin/files", "require('files');")
+
+	for id, expected := range map[string]string{
+		"core":           "object object",
+		"plugin/console": "object",
+	} {
+		if exports, err := environment.Require(id, false, nil); err == nil {
+			if value := exports.Get("value").String(); value != expected {
+				t.Errorf("%s: expected %q, got %q", id, expected, value)
+			}
+		} else {
+			t.Errorf("%s: %s", id, err)
+		}
+	}
+
+	for _, id := range []string{"plugin/os", "plugin/files"} {
+		if _, err := environment.Require(id, false, nil); !errors.Is(err, commonjs.ErrNotFound) {
+			t.Errorf("%s: expected %q: %v", id, commonjs.ErrNotFound, err)
+		}
+	}
+}
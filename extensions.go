@@ -1,11 +1,17 @@
 package commonjs
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+
 	"github.com/dop251/goja"
 )
 
 // Can return a goja.Value, nil, or other values, which will be converted to a
-// goja.Value.
+// goja.Value. If it returns an error then the extension value is undefined and
+// requiring the module fails with the error (or, for [Extension.Lazy]
+// extensions, accessing the extension throws it).
 type CreateExtensionFunc func(jsContext *Context) any
 
 //
@@ -15,45 +21,174 @@ type CreateExtensionFunc func(jsContext *Context) any
 type Extension struct {
 	Name   string
 	Create CreateExtensionFunc
+
+	// If true the extension will also be installed on the runtime's global
+	// object, making it available to code that is not in a module wrapper, e.g.
+	// [goja.Runtime.RunString] and callbacks. Modules still get their own
+	// instance as a wrapper parameter, which shadows the global one.
+	Global bool
+
+	// If true then Create will be called only when the extension is first
+	// accessed. See [Context.NewLazyExtension].
+	Lazy bool
+
+	// If true the extension is also a native module, so that it is available
+	// via "require" (e.g. require('console')) just like the extensions in
+	// [Environment.NativeModules].
+	Native bool
+
+	// Names of extensions that must be created before this one. Create can
+	// access them via [Context.GetExtension]. See [SortExtensions].
+	Dependencies []string
+
+	// Optional. Called once by [Environment.Release] (but not for child
+	// environments, which share their parent's extensions).
+	Close func(environment *Environment) error
 }
 
+// The extensions are sorted by name, so that the order is deterministic.
 func NewExtensions(extensions map[string]CreateExtensionFunc) []Extension {
 	if len(extensions) == 0 {
 		return nil
 	}
 
-	extensions_ := make([]Extension, len(extensions))
-	index := 0
-	for name, api := range extensions {
+	names := make([]string, 0, len(extensions))
+	for name := range extensions {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	extensions_ := make([]Extension, len(names))
+	for index, name := range names {
 		extensions_[index] = Extension{
 			Name:   name,
-			Create: api,
+			Create: extensions[name],
 		}
-		index++
 	}
 	return extensions_
 }
 
+// Sorts and validates the extensions (see [SortExtensions]) and then sets them
+// as [Environment.Extensions].
+func (self *Environment) SetExtensions(extensions ...Extension) error {
+	if extensions, err := SortExtensions(extensions); err == nil {
+		self.Extensions = extensions
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Installs the extensions for which [Extension.Global] is true on the runtime's
+// global object. They are created with a [Context] that has no URL, but that
+// does have their [Extension.Dependencies] (which are not installed unless they
+// are also global).
+//
+// This is called automatically when the first [Context] is created for the
+// environment. Calling it again will re-create and re-install the extensions.
+func (self *Environment) InstallGlobalExtensions() {
+	self.globalsInstalled.Store(true)
+
+	var globals []Extension
+	for _, extension := range self.Extensions {
+		if extension.Global {
+			globals = append(globals, extension)
+		}
+	}
+
+	if len(globals) == 0 {
+		return
+	}
+
+	jsContext := self.newContext(nil, nil, nil)
+	for _, extension := range includeDependencies(globals, self.Extensions) {
+		jsContext.AppendExtension(extension)
+		if extension.Global {
+			if value := jsContext.Extensions[len(jsContext.Extensions)-1]; !goja.IsUndefined(value) {
+				self.Runtime.Set(extension.Name, value)
+			}
+		}
+	}
+
+	if jsContext.extensionsErr != nil {
+		self.Log.Error(jsContext.extensionsErr.Error())
+	}
+}
+
+// Selects the extensions for this context (see [Environment.SelectExtensions])
+// and appends them.
 func (self *Context) AppendExtensions() {
-	for _, extension := range self.Environment.Extensions {
+	for _, extension := range self.Environment.selectExtensions(self) {
 		self.AppendExtension(extension)
 	}
 }
 
+// Extensions that return nil are appended as undefined so that
+// [Context.Extensions] remains aligned with the module wrapper parameters.
 func (self *Context) AppendExtension(extension Extension) {
-	if extension := self.CreateExtension(extension); extension != nil {
-		self.Extensions = append(self.Extensions, extension)
+	self.SelectedExtensions = append(self.SelectedExtensions, extension)
+	if value := self.newExtensionValue(extension); value != nil {
+		self.Extensions = append(self.Extensions, value)
+	} else {
+		self.Extensions = append(self.Extensions, goja.Undefined())
 	}
 }
 
-func (self *Context) CreateExtension(extension Extension) goja.Value {
-	if value := extension.Create(self); value != nil {
-		if value_, ok := value.(goja.Value); ok {
-			return value_
-		} else {
-			return self.Environment.Runtime.ToValue(value)
+// Returns the value of an extension that has already been created for this
+// context, which is the case for all of an extension's [Extension.Dependencies].
+func (self *Context) GetExtension(name string) (goja.Value, bool) {
+	for index, extension := range self.SelectedExtensions {
+		if extension.Name == name {
+			if index < len(self.Extensions) {
+				return self.Extensions[index], true
+			}
+			break
 		}
+	}
+	return nil, false
+}
+
+// If [Extension.Create] returns an error then returns nil, and the error will
+// fail the require of this context's module.
+func (self *Context) CreateExtension(extension Extension) goja.Value {
+	if value, err := self.createExtension(extension); err == nil {
+		return value
 	} else {
+		self.extensionsErr = errors.Join(self.extensionsErr, err)
 		return nil
 	}
 }
+
+func (self *Context) createExtension(extension Extension) (goja.Value, error) {
+	switch value := extension.Create(self).(type) {
+	case nil:
+		return nil, nil
+	case error:
+		return nil, fmt.Errorf("extension %q: %w", extension.Name, value)
+	case goja.Value:
+		return value, nil
+	default:
+		return self.Environment.Runtime.ToValue(value), nil
+	}
+}
+
+func (self *Context) newExtensionValue(extension Extension) goja.Value {
+	if extension.Lazy {
+		return self.NewLazyExtension(extension)
+	} else {
+		return self.CreateExtension(extension)
+	}
+}
+
+// Calls [Extension.Close] for all extensions that have it, in reverse order.
+func (self *Environment) CloseExtensions() error {
+	var errs []error
+	for index := len(self.Extensions) - 1; index >= 0; index-- {
+		if close := self.Extensions[index].Close; close != nil {
+			if err := close(self); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
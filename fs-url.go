@@ -0,0 +1,192 @@
+package commonjs
+
+import (
+	contextpkg "context"
+	"errors"
+	"fmt"
+	"io"
+	fspkg "io/fs"
+	pathpkg "path"
+	"strings"
+
+	"github.com/tliron/exturl"
+)
+
+const FS_URL_SCHEME = "fs"
+
+//
+// FSURL
+//
+
+// An [exturl.URL] backed by an [io/fs.FS], such as an [embed.FS].
+//
+// Keys have the form "fs:name!/path". The name should thus be unique among
+// all the FSURLs used in an [Environment], because it is what distinguishes
+// them in the exports and program caches.
+//
+// Can be used as one of [Environment.BasePaths], in which case it will be
+// combined with other base paths in priority order. The default resolver (see
+// [NewDefaultResolverCreator]) also supports absolute IDs in the "fs:name!/path"
+// form if an FSURL of that name is in its base paths.
+type FSURL struct {
+	Name string
+	FS   fspkg.FS
+
+	// Slash-separated path within the FS without a leading slash. Directories
+	// have a trailing slash. The root is an empty string.
+	Path string
+
+	urlContext *exturl.Context
+}
+
+// Returns an [FSURL] for the root of the FS. To use a subdirectory as the root
+// (common with [embed.FS]) use [io/fs.Sub].
+func NewFSURL(urlContext *exturl.Context, name string, fs fspkg.FS) *FSURL {
+	return &FSURL{
+		Name:       name,
+		FS:         fs,
+		urlContext: urlContext,
+	}
+}
+
+// ([fmt.Stringer] interface)
+func (self *FSURL) String() string {
+	return self.Key()
+}
+
+// ([exturl.URL] interface)
+func (self *FSURL) Format() string {
+	return exturl.GetFormat(self.Path)
+}
+
+// ([exturl.URL] interface)
+func (self *FSURL) Base() exturl.URL {
+	return self.withPath(fsDir(self.Path))
+}
+
+// ([exturl.URL] interface)
+func (self *FSURL) Relative(path string) exturl.URL {
+	path_, _ := self.relative(path)
+	return self.withPath(path_)
+}
+
+// ([exturl.URL] interface)
+func (self *FSURL) ValidRelative(context contextpkg.Context, path string) (exturl.URL, error) {
+	if path_, ok := self.relative(path); ok {
+		url := self.withPath(path_)
+		if err := url.validate(); err == nil {
+			return url, nil
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("path is outside of %q: %s", self.Name, path)
+	}
+}
+
+// ([exturl.URL] interface)
+func (self *FSURL) Key() string {
+	return FS_URL_SCHEME + ":" + self.Name + "!/" + self.Path
+}
+
+// ([exturl.URL] interface)
+func (self *FSURL) Open(context contextpkg.Context) (io.ReadCloser, error) {
+	return self.FS.Open(fsName(self.Path))
+}
+
+// ([exturl.URL] interface)
+func (self *FSURL) Context() *exturl.Context {
+	return self.urlContext
+}
+
+func (self *FSURL) withPath(path string) *FSURL {
+	return &FSURL{
+		Name:       self.Name,
+		FS:         self.FS,
+		Path:       path,
+		urlContext: self.urlContext,
+	}
+}
+
+func (self *FSURL) relative(path string) (string, bool) {
+	isDir := strings.HasSuffix(path, "/")
+
+	if strings.HasPrefix(path, "/") {
+		path = pathpkg.Clean(path)[1:]
+	} else {
+		path = pathpkg.Join(self.Path, path)
+	}
+
+	if (path == "..") || strings.HasPrefix(path, "../") {
+		return "", false
+	}
+
+	if path == "." {
+		path = ""
+	} else if isDir {
+		path += "/"
+	}
+
+	return path, true
+}
+
+func (self *FSURL) validate() error {
+	if info, err := fspkg.Stat(self.FS, fsName(self.Path)); err == nil {
+		if (self.Path == "") || strings.HasSuffix(self.Path, "/") {
+			if !info.IsDir() {
+				return fmt.Errorf("FS URL path does not point to a directory: %s", self.Key())
+			}
+		} else if !info.Mode().IsRegular() {
+			return fmt.Errorf("FS URL path does not point to a file: %s", self.Key())
+		}
+		return nil
+	} else if errors.Is(err, fspkg.ErrNotExist) {
+		return exturl.NewNotFoundf("FS URL path not found: %s", self.Key())
+	} else {
+		return err
+	}
+}
+
+// Parses IDs of the form "fs:name!/path" against the FSURLs in basePaths.
+// The returned bool is false if the ID is not of this form.
+func resolveFSURL(context contextpkg.Context, id string, basePaths []exturl.URL) (exturl.URL, bool, error) {
+	if rest, ok := strings.CutPrefix(id, FS_URL_SCHEME+":"); ok {
+		if name, path, ok := strings.Cut(rest, "!"); ok {
+			for _, basePath := range basePaths {
+				if fsUrl, ok := basePath.(*FSURL); ok && (fsUrl.Name == name) {
+					if !strings.HasPrefix(path, "/") {
+						path = "/" + path
+					}
+					url, err := fsUrl.ValidRelative(context, path)
+					return url, true, err
+				}
+			}
+			return nil, true, fmt.Errorf("FS not in base paths: %q", name)
+		}
+	}
+
+	return nil, false, nil
+}
+
+// Utils
+
+func fsDir(path string) string {
+	if (path == "") || strings.HasSuffix(path, "/") {
+		return path
+	}
+
+	if dir := pathpkg.Dir(path); dir != "." {
+		return dir + "/"
+	} else {
+		return ""
+	}
+}
+
+// Converts our path representation to an [io/fs.FS] name.
+func fsName(path string) string {
+	if path = strings.TrimSuffix(path, "/"); path == "" {
+		return "."
+	} else {
+		return path
+	}
+}
@@ -0,0 +1,46 @@
+package commonjs_test
+
+import (
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/commonjs-goja/api"
+	"github.com/tliron/exturl"
+)
+
+func TestFSURL(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	fs := fstest.MapFS{
+		"main.js":         {Data: []byte("const value = require('./lib/value');\nrequire('./lib/hello').sayit();\nexports.value = value.value;")},
+		"lib/value.js":    {Data: []byte("exports.value = require('fs:test!/lib/constant.js').value;")},
+		"lib/constant.js": {Data: []byte("exports.value = 'from fs';")},
+	}
+
+	path := filepath.Join(getRoot(t), "examples")
+
+	// The FS takes priority, and "lib/hello.js" falls back to the file base path
+	environment := commonjs.NewEnvironment(urlContext, commonjs.NewFSURL(urlContext, "test", fs), urlContext.NewFileURL(path))
+	defer environment.Release()
+
+	environment.Extensions = api.DefaultExtensions{}.Create()
+
+	if exports, err := environment.Require("./main", false, nil); err == nil {
+		if value := exports.Get("value").String(); value != "from fs" {
+			t.Errorf("unexpected value: %s", value)
+		}
+	} else {
+		t.Fatal(err)
+	}
+
+	if environment.Modules.Get("fs:test!/lib/value.js") == nil {
+		t.Errorf("module not registered: %v", environment.Modules.Keys())
+	}
+
+	if _, err := environment.Require("../outside", false, nil); err == nil {
+		t.Error("expected an error for a path outside the FS")
+	}
+}
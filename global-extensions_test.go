@@ -0,0 +1,66 @@
+package commonjs_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/commonjs-goja/api"
+	"github.com/tliron/exturl"
+)
+
+func TestGlobalExtensions(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	path := filepath.Join(getRoot(t), "examples")
+
+	environment := commonjs.NewEnvironment(urlContext, urlContext.NewFileURL(path))
+	defer environment.Release()
+
+	environment.Extensions = api.DefaultExtensions{Globals: true}.Create()
+
+	testEnvironment(t, environment)
+
+	// Outside of a module wrapper
+	if value, err := environment.Runtime.RunString("util.sprintf('%s', typeof console.log)"); err == nil {
+		if value.String() != "function" {
+			t.Errorf("unexpected value: %s", value)
+		}
+	} else {
+		t.Error(err)
+	}
+
+	// A global extension can get its (non-global) dependencies
+	environment = commonjs.NewEnvironment(urlContext, urlContext.NewFileURL(path))
+	defer environment.Release()
+
+	environment.Extensions = []commonjs.Extension{
+		{
+			Name: "greeting",
+			Create: func(jsContext *commonjs.Context) any {
+				return "hello"
+			},
+		},
+		{
+			Name:         "greeter",
+			Global:       true,
+			Dependencies: []string{"greeting"},
+			Create: func(jsContext *commonjs.Context) any {
+				if greeting, ok := jsContext.GetExtension("greeting"); ok {
+					return greeting
+				}
+				return nil
+			},
+		},
+	}
+
+	environment.InstallGlobalExtensions()
+	if value, err := environment.Runtime.RunString("[greeter, typeof greeting].join(' ')"); err == nil {
+		if value.String() != "hello undefined" {
+			t.Errorf("unexpected value: %s", value)
+		}
+	} else {
+		t.Error(err)
+	}
+}
@@ -5,6 +5,7 @@ go 1.25.1
 require (
 	github.com/beevik/etree v1.6.0
 	github.com/dop251/goja v0.0.0-20250630131328-58d95d85e994
+	github.com/go-sourcemap/sourcemap v2.1.3+incompatible
 	github.com/mitchellh/hashstructure/v2 v2.0.2
 	github.com/tliron/commonlog v0.2.21
 	github.com/tliron/exturl v0.4.6
@@ -33,7 +34,6 @@ require (
 	github.com/go-git/gcfg v1.5.1-0.20230307220236-3a3c6141e376 // indirect
 	github.com/go-git/go-billy/v5 v5.6.2 // indirect
 	github.com/go-git/go-git/v5 v5.16.2 // indirect
-	github.com/go-sourcemap/sourcemap v2.1.3+incompatible // indirect
 	github.com/goccy/go-yaml v1.18.0 // indirect
 	github.com/golang/groupcache v0.0.0-20241129210726-2c02b8208cf8 // indirect
 	github.com/google/go-containerregistry v0.20.6 // indirect
@@ -0,0 +1,131 @@
+package commonjs
+
+import (
+	"fmt"
+
+	"github.com/dop251/goja"
+)
+
+// Creates an [Extension] whose value comes from a JavaScript module. The id is
+// resolved like an [Environment.Require] with a non-bare ID, i.e. against
+// [Environment.BasePaths].
+//
+// If perContext is false, the extension value is the module's exports. The
+// module is required once per environment (it is cached like any other module).
+//
+// If perContext is true, the module's exports must be a function. It is called
+// for every [Context] with the context's module object as its argument and its
+// return value is used as the extension value.
+//
+// The module, as well as anything it requires while it is being loaded, will
+// not receive this extension (its wrapper parameter will be undefined). This
+// avoids the bootstrap cycle of the module receiving itself. Modules that it
+// requires are not cached, so that they get the extension when they are
+// required again by other modules. Errors fail the require of the module that
+// selected the extension (see [CreateExtensionFunc]).
+func NewJavaScriptExtension(name string, id string, perContext bool) Extension {
+	return Extension{
+		Name: name,
+		Create: func(jsContext *Context) any {
+			environment := jsContext.Environment
+
+			exports, err := environment.requireJavaScriptExtension(name, id, jsContext.UserContext)
+			if err != nil {
+				return err
+			} else if exports == nil {
+				return nil
+			}
+
+			if !perContext {
+				return exports
+			}
+
+			if call, ok := goja.AssertFunction(exports); ok {
+				var module goja.Value = goja.Undefined()
+				if jsContext.Module != nil {
+					module = environment.Runtime.ToValue(jsContext.Module)
+				}
+
+				if value, err := call(nil, module); err == nil {
+					return value
+				} else {
+					return UnwrapJavaScriptException(err)
+				}
+			} else {
+				return fmt.Errorf("exports are not a function: %s", id)
+			}
+		},
+	}
+}
+
+// Returns nil exports if the extension is being bootstrapped. The exports are
+// memoized per environment.
+func (self *Environment) requireJavaScriptExtension(name string, id string, userContext any) (*goja.Object, error) {
+	if exports, ok := self.javaScriptExtensions.Load(name); ok {
+		return exports.(*goja.Object), nil
+	}
+
+	if _, loading := self.loadingExtensions.LoadOrStore(name, true); loading {
+		return nil, nil
+	}
+	defer self.loadingExtensions.Delete(name)
+
+	start := self.beginBootstrap()
+	defer self.endBootstrap(start)
+
+	context, cancelContext := self.NewTimeoutContext()
+	defer cancelContext()
+
+	jsContext := self.NewContext(nil, nil, userContext)
+	if url, err := jsContext.Resolve(context, id, false); err == nil {
+		jsContext.initialize(url)
+		if exports, err := jsContext.require(context); err == nil {
+			exports, _ := self.javaScriptExtensions.LoadOrStore(name, exports)
+			return exports.(*goja.Object), nil
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// Returns the index in the bootstrap list at which this bootstrap starts.
+func (self *Environment) beginBootstrap() int {
+	self.bootstrapLock.Lock()
+	defer self.bootstrapLock.Unlock()
+
+	self.bootstrapping++
+	return len(self.bootstrapKeys)
+}
+
+// Uncaches the modules first loaded during the bootstrap, except for the
+// extension module itself, which is the first one.
+func (self *Environment) endBootstrap(start int) {
+	self.bootstrapLock.Lock()
+	var keys []string
+	if len(self.bootstrapKeys) > start+1 {
+		keys = self.bootstrapKeys[start+1:]
+	}
+	self.bootstrapping--
+	if self.bootstrapping == 0 {
+		self.bootstrapKeys = nil
+	}
+	self.bootstrapLock.Unlock()
+
+	for _, key := range keys {
+		self.exportsCache.Delete(key)
+		self.Lock.Lock()
+		self.Modules.Delete(key)
+		self.Lock.Unlock()
+	}
+}
+
+func (self *Environment) addToBootstrap(key string) {
+	self.bootstrapLock.Lock()
+	defer self.bootstrapLock.Unlock()
+
+	if self.bootstrapping > 0 {
+		self.bootstrapKeys = append(self.bootstrapKeys, key)
+	}
+}
@@ -0,0 +1,167 @@
+package commonjs
+
+import (
+	"github.com/dop251/goja"
+)
+
+// Creates a stand-in for an extension for which [Extension.Lazy] is true. The
+// stand-in is a proxy that calls [Extension.Create] only when it is first
+// accessed, i.e. when a property (including a symbol, e.g. Symbol.iterator) is
+// read, written, or enumerated, or when it is called as a function or
+// constructor. All access is then forwarded to the created value. If creation fails the error is thrown
+// (and creation is tried again on the next access).
+//
+// Note that the proxy's target is a function, so "typeof" for lazy extensions
+// is always "function".
+func (self *Context) NewLazyExtension(extension Extension) goja.Value {
+	runtime := self.Environment.Runtime
+
+	var value *goja.Object
+	var created bool
+	get := func() *goja.Object {
+		if !created {
+			if value_, err := self.createExtension(extension); err == nil {
+				created = true
+				if (value_ != nil) && !goja.IsUndefined(value_) && !goja.IsNull(value_) {
+					value = value_.ToObject(runtime)
+				}
+			} else {
+				panic(runtime.NewGoError(err))
+			}
+		}
+		return value
+	}
+
+	// The target is a function so that the "apply" and "construct" traps work
+	target := runtime.ToValue(func(call goja.FunctionCall) goja.Value {
+		return goja.Undefined()
+	}).ToObject(runtime)
+
+	proxy := runtime.NewProxy(target, &goja.ProxyTrapConfig{
+		Get: func(target *goja.Object, property string, receiver goja.Value) goja.Value {
+			if value := get(); value != nil {
+				return value.Get(property)
+			}
+			return goja.Undefined()
+		},
+
+		GetSym: func(target *goja.Object, property *goja.Symbol, receiver goja.Value) goja.Value {
+			if value := get(); value != nil {
+				return value.GetSymbol(property)
+			}
+			return goja.Undefined()
+		},
+
+		Set: func(target *goja.Object, property string, value goja.Value, receiver goja.Value) bool {
+			if value_ := get(); value_ != nil {
+				return value_.Set(property, value) == nil
+			}
+			return false
+		},
+
+		SetSym: func(target *goja.Object, property *goja.Symbol, value goja.Value, receiver goja.Value) bool {
+			if value_ := get(); value_ != nil {
+				return value_.SetSymbol(property, value) == nil
+			}
+			return false
+		},
+
+		Has: func(target *goja.Object, property string) bool {
+			if value := get(); value != nil {
+				return value.Get(property) != nil
+			}
+			return false
+		},
+
+		HasSym: func(target *goja.Object, property *goja.Symbol) bool {
+			if value := get(); value != nil {
+				return value.GetSymbol(property) != nil
+			}
+			return false
+		},
+
+		DeleteProperty: func(target *goja.Object, property string) bool {
+			if value := get(); value != nil {
+				return value.Delete(property) == nil
+			}
+			return false
+		},
+
+		DeletePropertySym: func(target *goja.Object, property *goja.Symbol) bool {
+			if value := get(); value != nil {
+				return value.DeleteSymbol(property) == nil
+			}
+			return false
+		},
+
+		OwnKeys: func(target *goja.Object) *goja.Object {
+			var keys []any
+			if value := get(); value != nil {
+				for _, key := range value.Keys() {
+					keys = append(keys, key)
+				}
+				for _, key := range value.Symbols() {
+					keys = append(keys, key)
+				}
+			}
+			return runtime.NewArray(keys...)
+		},
+
+		GetOwnPropertyDescriptor: func(target *goja.Object, property string) goja.PropertyDescriptor {
+			if value := get(); value != nil {
+				if value_ := value.Get(property); value_ != nil {
+					// Must be configurable, because the target doesn't have the property
+					return goja.PropertyDescriptor{
+						Value:        value_,
+						Writable:     goja.FLAG_TRUE,
+						Enumerable:   goja.FLAG_TRUE,
+						Configurable: goja.FLAG_TRUE,
+					}
+				}
+			}
+			return goja.PropertyDescriptor{}
+		},
+
+		GetOwnPropertyDescriptorSym: func(target *goja.Object, property *goja.Symbol) goja.PropertyDescriptor {
+			if value := get(); value != nil {
+				if value_ := value.GetSymbol(property); value_ != nil {
+					return goja.PropertyDescriptor{
+						Value:        value_,
+						Writable:     goja.FLAG_TRUE,
+						Enumerable:   goja.FLAG_TRUE,
+						Configurable: goja.FLAG_TRUE,
+					}
+				}
+			}
+			return goja.PropertyDescriptor{}
+		},
+
+		Apply: func(target *goja.Object, this goja.Value, arguments []goja.Value) goja.Value {
+			if value := get(); value != nil {
+				if call, ok := goja.AssertFunction(value); ok {
+					if value, err := call(this, arguments...); err == nil {
+						return value
+					} else {
+						panic(err)
+					}
+				}
+			}
+			panic(runtime.NewTypeError("extension %q is not a function", extension.Name))
+		},
+
+		Construct: func(target *goja.Object, arguments []goja.Value, newTarget *goja.Object) *goja.Object {
+			if value := get(); value != nil {
+				if constructor, ok := goja.AssertConstructor(value); ok {
+					if object, err := constructor(nil, arguments...); err == nil {
+						return object
+					} else {
+						panic(err)
+					}
+				}
+			}
+			panic(runtime.NewTypeError("extension %q is not a constructor", extension.Name))
+		},
+	})
+
+	return runtime.ToValue(proxy)
+}
@@ -0,0 +1,79 @@
+package commonjs_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/commonjs-goja/api"
+	"github.com/tliron/exturl"
+)
+
+func TestLazyExtensions(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	path := filepath.Join(getRoot(t), "examples")
+
+	environment := commonjs.NewEnvironment(urlContext, urlContext.NewFileURL(path))
+	defer environment.Release()
+
+	var created int
+	environment.Extensions = []commonjs.Extension{{
+		Name: "util",
+		Create: func(jsContext *commonjs.Context) any {
+			created++
+			return api.CreateUtilExtension(jsContext)
+		},
+		Lazy: true,
+	}, {
+		Name: "twice",
+		Create: func(jsContext *commonjs.Context) any {
+			created++
+			return func(value int) int { return value * 2 }
+		},
+		Lazy: true,
+	}}
+
+	environment.DefineModuleSource("unused", "exports.value = 1;")
+	if _, err := environment.Require("unused", false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if created != 0 {
+		t.Errorf("expected no extensions to be created, got %d", created)
+	}
+
+	environment.DefineModuleSource("used", "exports.value = util.sprintf('%d', twice(2)) + util.sprintf('%d', twice(3));")
+	if exports, err := environment.Require("used", false, nil); err == nil {
+		if value := exports.Get("value").String(); value != "46" {
+			t.Errorf("unexpected value: %s", value)
+		}
+	} else {
+		t.Fatal(err)
+	}
+	if created != 2 {
+		t.Errorf("expected 2 extensions to be created, got %d", created)
+	}
+
+	// Symbols are forwarded
+	environment.Extensions = append(environment.Extensions, commonjs.Extension{
+		Name: "sequence",
+		Create: func(jsContext *commonjs.Context) any {
+			if sequence, err := jsContext.Environment.Runtime.RunString("({*[Symbol.iterator]() { yield 1; yield 2; }, [Symbol.toPrimitive]() { return 3; }})"); err == nil {
+				return sequence
+			} else {
+				panic(err)
+			}
+		},
+		Lazy: true,
+	})
+
+	environment.DefineModuleSource("symbols", "exports.value = [...sequence].join() + ' ' + (+sequence) + ' ' + (Symbol.iterator in sequence) + ' ' + Object.getOwnPropertySymbols(sequence).length;")
+	if exports, err := environment.Require("symbols", false, nil); err == nil {
+		if value := exports.Get("value").String(); value != "1,2 3 true 2" {
+			t.Errorf("unexpected value: %s", value)
+		}
+	} else {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,177 @@
+package commonjs
+
+import (
+	contextpkg "context"
+	"errors"
+	"io/fs"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/parser"
+)
+
+// Kinds of [*LoadError]. Test with [errors.Is].
+var (
+	ErrNotFound    = errors.New("module not found")
+	ErrSyntax      = errors.New("syntax error")
+	ErrRuntime     = errors.New("exception during module initialization")
+	ErrTimeout     = errors.New("timeout")
+	ErrInterrupted = errors.New("interrupted")
+)
+
+//
+// LoadError
+//
+
+// An error returned by [Environment.Require], [Context.Resolve] and
+// [Context.Require] (and the functions that call them) when a module could not
+// be loaded.
+//
+// The kind is one of [ErrNotFound], [ErrSyntax], [ErrRuntime], [ErrTimeout], or
+// [ErrInterrupted]. For [ErrSyntax] the error is a [*SyntaxError] and for
+// [ErrRuntime] it is usually a [*JavaScriptError].
+//
+// If a module fails because a module it requires failed, then the kind is that
+// of the innermost failure.
+type LoadError struct {
+	Kind error
+	Err  error
+}
+
+// ([error] interface)
+func (self *LoadError) Error() string {
+	return self.Err.Error()
+}
+
+// Support for [errors.Unwrap].
+func (self *LoadError) Unwrap() error {
+	return self.Err
+}
+
+// Support for [errors.Is].
+func (self *LoadError) Is(target error) bool {
+	return target == self.Kind
+}
+
+// If err is already a [*LoadError], or contains one, it is returned as is.
+// Otherwise it is wrapped in one, with context errors taking precedence over
+// the provided kind.
+func newLoadError(context contextpkg.Context, kind error, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var loadError *LoadError
+	if errors.As(err, &loadError) {
+		return err
+	}
+
+	var interruptedError *goja.InterruptedError
+	if errors.As(err, &interruptedError) {
+		// Runtime.Interrupt might have been called with a context error
+		kind = ErrInterrupted
+	}
+
+	if errors.Is(err, contextpkg.DeadlineExceeded) || ((context != nil) && errors.Is(context.Err(), contextpkg.DeadlineExceeded)) {
+		kind = ErrTimeout
+	} else if errors.Is(err, contextpkg.Canceled) || ((context != nil) && errors.Is(context.Err(), contextpkg.Canceled)) {
+		kind = ErrInterrupted
+	}
+
+	return &LoadError{
+		Kind: kind,
+		Err:  err,
+	}
+}
+
+// Errors reading a resolved module are "not found" only if the file doesn't
+// exist (e.g. it was deleted after resolution).
+func newReadError(context contextpkg.Context, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return newLoadError(context, ErrNotFound, err)
+	}
+
+	if context.Err() != nil {
+		// Will become ErrTimeout if the deadline was exceeded
+		return newLoadError(context, ErrInterrupted, err)
+	}
+
+	return err
+}
+
+//
+// SyntaxError
+//
+
+// A syntax error in a module's source. The position refers to the original
+// source. See also [LoadError].
+type SyntaxError struct {
+	File    string
+	Line    int // 1-based; 0 if unknown
+	Column  int // 1-based; 0 if unknown
+	Message string
+	Err     error // a [*goja.CompilerSyntaxError] or a [*goja.CompilerReferenceError]
+}
+
+// ([error] interface)
+func (self *SyntaxError) Error() string {
+	return self.Err.Error()
+}
+
+// Support for [errors.Unwrap].
+func (self *SyntaxError) Unwrap() error {
+	return self.Err
+}
+
+// Support for [errors.Is].
+func (self *SyntaxError) Is(target error) bool {
+	return target == ErrSyntax
+}
+
+func newParserSyntaxError(err error) *SyntaxError {
+	// Same as goja.Compile
+	syntaxError := SyntaxError{
+		Message: err.Error(),
+		Err: &goja.CompilerSyntaxError{
+			CompilerError: goja.CompilerError{
+				Message: err.Error(),
+			},
+		},
+	}
+
+	var errorList parser.ErrorList
+	if errors.As(err, &errorList) && (len(errorList) > 0) {
+		syntaxError.File = errorList[0].Position.Filename
+		syntaxError.Line = errorList[0].Position.Line
+		syntaxError.Column = errorList[0].Position.Column
+		syntaxError.Message = errorList[0].Message
+	}
+
+	return &syntaxError
+}
+
+func newCompilerSyntaxError(err error) error {
+	var compilerError *goja.CompilerError
+	var syntaxError *goja.CompilerSyntaxError
+	var referenceError *goja.CompilerReferenceError
+	if errors.As(err, &syntaxError) {
+		compilerError = &syntaxError.CompilerError
+	} else if errors.As(err, &referenceError) {
+		compilerError = &referenceError.CompilerError
+	} else {
+		return err
+	}
+
+	syntaxError_ := SyntaxError{
+		Message: compilerError.Message,
+		Err:     err,
+	}
+
+	if compilerError.File != nil {
+		position := compilerError.File.Position(compilerError.Offset)
+		syntaxError_.File = position.Filename
+		syntaxError_.Line = position.Line
+		syntaxError_.Column = position.Column
+	}
+
+	return &syntaxError_
+}
@@ -0,0 +1,118 @@
+package commonjs_test
+
+import (
+	contextpkg "context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/exturl"
+)
+
+func TestLoadErrors(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	environment.Extensions = []commonjs.Extension{{
+		Name: "interrupt",
+		Create: func(jsContext *commonjs.Context) any {
+			return func() {
+				jsContext.Environment.Runtime.Interrupt("stop")
+			}
+		},
+	}}
+
+	environment.DefineModuleSource("syntax", "\nlet a = ;")
+	environment.DefineModuleSource("runtime", "throw new Error('init');")
+	environment.DefineModuleSource("interrupted", "interrupt(); for (;;) {}")
+	environment.DefineModuleSource("nested", "require('missing');")
+	environment.DefineModuleSource("timeout", "for (;;) {}")
+
+	environment.ExecutionTimeout = 200 * time.Millisecond
+
+	for id, kind := range map[string]error{
+		"missing":     commonjs.ErrNotFound,
+		"syntax":      commonjs.ErrSyntax,
+		"runtime":     commonjs.ErrRuntime,
+		"interrupted": commonjs.ErrInterrupted,
+		"nested":      commonjs.ErrNotFound,
+		"timeout":     commonjs.ErrTimeout,
+	} {
+		_, err := environment.Require(id, false, nil)
+		if !errors.Is(err, kind) {
+			t.Errorf("%s: expected %q: %v", id, kind, err)
+		}
+
+		var loadError *commonjs.LoadError
+		if !errors.As(err, &loadError) {
+			t.Errorf("%s: not a LoadError: %T %v", id, err, err)
+		}
+	}
+
+	// The timeout interrupt does not affect later code
+	if _, err := environment.Runtime.RunString("1"); err != nil {
+		t.Errorf("unexpected error after timeout: %s", err)
+	}
+
+	// Timeout is only for I/O
+	environment.Timeout = 100 * time.Millisecond
+	environment.ExecutionTimeout = 0
+	environment.DefineModuleSource("slow", "const start = Date.now(); while (Date.now() - start < 300) {}")
+	if _, err := environment.Require("slow", false, nil); err != nil {
+		t.Errorf("slow: %s", err)
+	}
+	environment.DefineModuleSource("slow runtime", "const start = Date.now(); while (Date.now() - start < 300) {}\nthrow new Error('slow');")
+	if _, err := environment.Require("slow runtime", false, nil); !errors.Is(err, commonjs.ErrRuntime) {
+		t.Errorf("slow runtime: expected %q: %v", commonjs.ErrRuntime, err)
+	}
+
+	_, err := environment.Require("syntax", false, nil)
+	var syntaxError *commonjs.SyntaxError
+	if errors.As(err, &syntaxError) {
+		if (syntaxError.File != "virtual:syntax") || (syntaxError.Line != 2) || (syntaxError.Column != 9) {
+			t.Errorf("unexpected syntax error position: %s:%d:%d", syntaxError.File, syntaxError.Line, syntaxError.Column)
+		}
+	} else {
+		t.Errorf("not a SyntaxError: %T %v", err, err)
+	}
+
+	_, err = environment.Require("runtime", false, nil)
+	var jsError *commonjs.JavaScriptError
+	if !errors.As(err, &jsError) || (jsError.Message != "init") {
+		t.Errorf("not a JavaScriptError: %T %v", err, err)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	broken := errors.New("broken resolver")
+	environment.CreateResolver = func(fromUrl exturl.URL, jsContext *commonjs.Context) commonjs.ResolveFunc {
+		return func(context contextpkg.Context, id string, bareId bool) (exturl.URL, error) {
+			switch id {
+			case "missing":
+				return nil, fmt.Errorf("no such module: %w", fs.ErrNotExist)
+			default:
+				return nil, broken
+			}
+		}
+	}
+
+	if _, err := environment.Require("missing", false, nil); !errors.Is(err, commonjs.ErrNotFound) {
+		t.Errorf("expected %q: %v", commonjs.ErrNotFound, err)
+	}
+
+	if _, err := environment.Require("broken", false, nil); !errors.Is(err, broken) || errors.Is(err, commonjs.ErrNotFound) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package commonjs
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/file"
+	"github.com/dop251/goja/parser"
+)
+
+// See: https://nodejs.org/api/modules.html#modules_the_module_wrapper
+const moduleWrapperParameters = "exports, require, module, __filename, __dirname"
+
+// Returns the wrapped script and the length of the wrapper's prefix.
+//
+// The prefix is on the same line as the beginning of the script, so that line
+// numbers are not shifted. The suffix is on its own line in case the script
+// ends with a line comment.
+func wrapModule(script string, extensions []Extension) (string, int) {
+	var builder strings.Builder
+	builder.WriteString("(function(" + moduleWrapperParameters)
+	for _, extension := range extensions {
+		builder.WriteString(", ")
+		builder.WriteString(extension.Name)
+	}
+	builder.WriteString(") {")
+	prefixLength := builder.Len()
+	builder.WriteString(script)
+	builder.WriteString("\n});")
+	return builder.String(), prefixLength
+}
+
+// Parses the script inside the module wrapper.
+//
+// All positions in the returned AST, as well as in parser errors, refer to the
+// original script rather than to the wrapped one. This is also true for
+// programs compiled from the AST, so that positions reported by goja (stack
+// traces, [JavaScriptError], console.trace) match the module's source exactly.
+//
+// If a source map is provided then positions are further mapped through it.
+func parseWrappedModule(name string, script string, sourceMap []byte, extensions []Extension) (*ast.Program, error) {
+	wrapped, prefixLength := wrapModule(script, extensions)
+
+	if program, err := parser.ParseFile(nil, name, wrapped, 0, parser.WithDisableSourceMaps); err == nil {
+		if err := shiftAST(reflect.ValueOf(program), prefixLength, make(map[uintptr]struct{}), make(map[uintptr]struct{})); err != nil {
+			return nil, err
+		}
+		program.File = file.NewFile(name, script, 1)
+
+		if sourceMap != nil {
+			if sourceMap_, err := newSourceMap(name, sourceMap); err == nil {
+				program.File.SetSourceMap(sourceMap_)
+			} else {
+				return nil, err
+			}
+		}
+
+		return program, nil
+	} else {
+		if errors, ok := err.(parser.ErrorList); ok {
+			for _, error_ := range errors {
+				if error_.Position.Line == 1 {
+					error_.Position.Column = max(error_.Position.Column-prefixLength, 1)
+				}
+			}
+		}
+		return nil, err
+	}
+}
+
+// Like [goja.Compile] but for the wrapped script. See [parseWrappedModule].
+//
+// Syntax errors are returned as [*SyntaxError].
+func compileWrappedModule(name string, script string, sourceMap []byte, extensions []Extension, strict bool) (*goja.Program, error) {
+	if program, err := parseWrappedModule(name, script, sourceMap, extensions); err == nil {
+		if program_, err := goja.CompileAST(program, strict); err == nil {
+			return program_, nil
+		} else {
+			return nil, newCompilerSyntaxError(err)
+		}
+	} else {
+		if _, ok := err.(parser.ErrorList); ok {
+			return nil, newParserSyntaxError(err)
+		}
+		return nil, err
+	}
+}
+
+var fileIdxType = reflect.TypeOf(file.Idx(0))
+
+// Moves all positions back by the length of the wrapper's prefix. Positions
+// within the prefix are moved to the beginning of the script.
+//
+// Note that we cannot instead parse with a file base (or a [file.FileSet])
+// that accounts for the prefix, because goja's compiler ignores the base and
+// treats every position as an offset into [ast.Program.File] starting at 1.
+// And because the prefix must stay on the first line (so that line numbers are
+// not shifted), a [file.File] over the wrapped script cannot produce the right
+// columns for that line either.
+//
+// The same node (or field) can be reachable more than once (e.g. via
+// DeclarationList), so we make sure to shift each one only once. Returns an
+// error if a position cannot be shifted, rather than leaving it wrong.
+func shiftAST(value reflect.Value, prefixLength int, pointers map[uintptr]struct{}, fields map[uintptr]struct{}) error {
+	switch value.Kind() {
+	case reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+
+		elem := value.Elem()
+		if (elem.Kind() == reflect.Struct) && !elem.CanSet() {
+			// A node held by value is not addressable, so we shift a copy and put
+			// it back
+			if !value.CanSet() {
+				return fmt.Errorf("cannot shift positions in %s", elem.Type())
+			}
+
+			copy := reflect.New(elem.Type()).Elem()
+			copy.Set(elem)
+			if err := shiftAST(copy, prefixLength, pointers, fields); err != nil {
+				return err
+			}
+			value.Set(copy)
+			return nil
+		}
+
+		return shiftAST(elem, prefixLength, pointers, fields)
+
+	case reflect.Pointer:
+		if value.IsNil() || (value.Type() == astFileType) {
+			return nil
+		}
+
+		pointer := value.Pointer()
+		if _, ok := pointers[pointer]; ok {
+			return nil
+		}
+		pointers[pointer] = struct{}{}
+
+		return shiftAST(value.Elem(), prefixLength, pointers, fields)
+
+	case reflect.Struct:
+		type_ := value.Type()
+		for index := range value.NumField() {
+			if type_.Field(index).IsExported() {
+				if err := shiftAST(value.Field(index), prefixLength, pointers, fields); err != nil {
+					return err
+				}
+			}
+		}
+
+	case reflect.Slice:
+		for index := range value.Len() {
+			if err := shiftAST(value.Index(index), prefixLength, pointers, fields); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Int:
+		if value.Type() != fileIdxType {
+			return nil
+		}
+
+		if !value.CanSet() {
+			return errors.New("cannot shift an unaddressable position")
+		}
+
+		address := value.Addr().Pointer()
+		if _, ok := fields[address]; ok {
+			return nil
+		}
+		fields[address] = struct{}{}
+
+		// Zero means "no position"
+		if idx := int(value.Int()); idx > prefixLength {
+			value.SetInt(int64(idx - prefixLength))
+		} else if idx > 0 {
+			value.SetInt(1)
+		}
+	}
+
+	return nil
+}
@@ -18,6 +18,9 @@ type Module struct {
 	Require      *goja.Object
 	IsPreloading bool
 	Loaded       bool
+
+	loadOrder   int
+	environment *Environment // see: Module.loadedChildren
 }
 
 func (self *Environment) NewModule() *Module {
@@ -30,9 +33,30 @@ func (self *Environment) NewModule() *Module {
 		Paths:        path,
 		Exports:      self.Runtime.NewObject(),
 		IsPreloading: true,
+		environment:  self,
+	}
+}
+
+// Returns the children that have been loaded. Children are added and marked as
+// loaded while the environment is locked (see: Environment.newContext,
+// Context.markLoaded), because they can be required from other goroutines,
+// e.g. by binds.
+func (self *Module) loadedChildren() []*Module {
+	if self.environment != nil {
+		self.environment.Lock.Lock()
+		defer self.environment.Lock.Unlock()
+	}
+
+	var children []*Module
+	for _, child := range self.Children {
+		if child.Loaded && (child.Id != "") {
+			children = append(children, child)
+		}
 	}
+	return children
 }
 
 func (self *Environment) AddModule(module *Module) {
+	module.loadOrder = int(self.loadCounter.Add(1))
 	self.Modules.Set(module.Id, module)
 }
@@ -0,0 +1,147 @@
+package commonjs
+
+import (
+	contextpkg "context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/tliron/exturl"
+)
+
+const NATIVE_URL_SCHEME = "native"
+
+// Looks in [Environment.NativeModules] and then in the [Extension.Native]
+// extensions. Like extensions, native modules are subject to the requiring
+// module's selection (see [Environment.SelectExtensions]).
+func (self *Environment) resolveNativeModule(id string, jsContext *Context) (*NativeURL, bool) {
+	// Selection only applies to modules (see: Context.AppendExtensions)
+	selected := (self.SelectExtensions == nil) || (jsContext == nil) || (jsContext.URL == nil)
+
+	name := strings.TrimPrefix(id, NATIVE_URL_SCHEME+":")
+	name = strings.TrimPrefix(name, "node:")
+	for _, nativeModule := range self.NativeModules {
+		if nativeModule.Name == name {
+			if selected || (len(self.SelectExtensions(jsContext, []Extension{nativeModule})) > 0) {
+				return self.newNativeURL(nativeModule), true
+			}
+			return nil, false
+		}
+	}
+	for _, extension := range self.Extensions {
+		if extension.Native && (extension.Name == name) {
+			if selected || slices.ContainsFunc(jsContext.SelectedExtensions, func(extension Extension) bool {
+				return extension.Name == name
+			}) {
+				return self.newNativeURL(extension), true
+			}
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+func (self *Environment) newNativeURL(nativeModule Extension) *NativeURL {
+	return &NativeURL{
+		Name:       nativeModule.Name,
+		create:     nativeModule.Create,
+		urlContext: self.URLContext,
+	}
+}
+
+// Native modules are not in the environment's cache. Instead, they are created
+// once per requiring module, with the requiring module's [Context], so that
+// per-module state (e.g. the module ID in "console") is that of the requiring
+// module, just like for extensions.
+func (self *Context) requireNative(key string) (*goja.Object, error) {
+	creator := self
+	if self.Parent != nil {
+		creator = self.Parent
+		if exports, ok := self.Parent.nativeExports[key]; ok {
+			self.Module.Exports = exports
+			self.markLoaded()
+			return exports, nil
+		}
+	}
+
+	url := self.URL.(*NativeURL)
+	if value := creator.CreateExtension(Extension{Name: url.Name, Create: url.create}); (value != nil) && !goja.IsUndefined(value) && !goja.IsNull(value) {
+		exports := value.ToObject(self.Environment.Runtime)
+		self.Module.Exports = exports
+		self.Environment.AddModule(self.Module)
+		self.markLoaded()
+
+		if self.Parent != nil {
+			if self.Parent.nativeExports == nil {
+				self.Parent.nativeExports = make(map[string]*goja.Object)
+			}
+			self.Parent.nativeExports[key] = exports
+		}
+
+		return exports, nil
+	} else {
+		return nil, newRequireError(self.URL.String(), self.Parent, fmt.Errorf("native module %q has no exports", url.Name))
+	}
+}
+
+//
+// NativeURL
+//
+
+// The [exturl.URL] of a module in [Environment.NativeModules] or of an
+// [Extension.Native] extension.
+type NativeURL struct {
+	Name string
+
+	create     CreateExtensionFunc
+	urlContext *exturl.Context
+}
+
+// ([fmt.Stringer] interface)
+func (self *NativeURL) String() string {
+	return self.Key()
+}
+
+// ([exturl.URL] interface)
+func (self *NativeURL) Format() string {
+	return ""
+}
+
+// Native modules have no base, so this returns the URL itself.
+//
+// ([exturl.URL] interface)
+func (self *NativeURL) Base() exturl.URL {
+	return self
+}
+
+// Native modules have no relative URLs, so this returns the URL itself.
+//
+// ([exturl.URL] interface)
+func (self *NativeURL) Relative(path string) exturl.URL {
+	return self
+}
+
+// Always returns an error, which allows resolvers to move on to their other
+// base paths.
+//
+// ([exturl.URL] interface)
+func (self *NativeURL) ValidRelative(context contextpkg.Context, path string) (exturl.URL, error) {
+	return nil, exturl.NewNotFoundf("native module %q has no relative paths: %s", self.Name, path)
+}
+
+// ([exturl.URL] interface)
+func (self *NativeURL) Key() string {
+	return NATIVE_URL_SCHEME + ":" + self.Name
+}
+
+// ([exturl.URL] interface)
+func (self *NativeURL) Open(context contextpkg.Context) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("native module %q has no source", self.Name)
+}
+
+// ([exturl.URL] interface)
+func (self *NativeURL) Context() *exturl.Context {
+	return self.urlContext
+}
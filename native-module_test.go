@@ -0,0 +1,82 @@
+package commonjs_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/dop251/goja"
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/commonjs-goja/api"
+	"github.com/tliron/exturl"
+)
+
+func TestNativeModule(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	path := filepath.Join(getRoot(t), "examples")
+
+	environment := commonjs.NewEnvironment(urlContext, urlContext.NewFileURL(path))
+	defer environment.Release()
+
+	api.DefaultExtensions{}.Install(environment)
+
+	environment.DefineModuleSource("main", "const console_ = require('console');\nconst util_ = require('node:util');\nconsole_.log('native');\nexports.value = util_.sprintf('%d', 1);\nexports.same = require('env') === require('env');\nexports.env = require('env');\nexports.other = require('other');")
+	environment.DefineModuleSource("other", "module.exports = require('env');")
+
+	if exports, err := environment.Require("main", false, nil); err == nil {
+		if value := exports.Get("value").String(); value != "1" {
+			t.Errorf("unexpected value: %s", value)
+		}
+
+		// Created once per requiring module
+		if !exports.Get("same").ToBoolean() {
+			t.Error("native module was created twice for the same module")
+		}
+		if exports.Get("env").SameAs(exports.Get("other")) {
+			t.Error("native module was shared between modules")
+		}
+	} else {
+		t.Fatal(err)
+	}
+
+	if environment.Modules.Get("native:console") == nil {
+		t.Errorf("module not registered: %v", environment.Modules.Keys())
+	}
+}
+
+func TestNativeExtension(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	environment.Extensions = append(api.DefaultExtensions{}.Create(), commonjs.Extension{
+		Name: "local",
+		Create: func(jsContext *commonjs.Context) any {
+			return map[string]any{}
+		},
+	})
+
+	environment.DefineModuleSource("main", "exports.console = require('console');\nexports.util = require('node:util');")
+	environment.DefineModuleSource("local", "exports.virtual = true;")
+
+	if exports, err := environment.Require("main", false, nil); err == nil {
+		if goja.IsUndefined(exports.Get("console")) || goja.IsUndefined(exports.Get("util")) {
+			t.Error("default extensions are not native modules")
+		}
+	} else {
+		t.Fatal(err)
+	}
+
+	// Other extensions are not native modules
+	environment.DefineModuleSource("other", "module.exports = require('local');")
+	if exports, err := environment.Require("other", false, nil); err == nil {
+		if !exports.Get("virtual").ToBoolean() {
+			t.Error("extension is a native module")
+		}
+	} else {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,318 @@
+package commonjs
+
+import (
+	contextpkg "context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/tliron/exturl"
+)
+
+//
+// PooledBind
+//
+
+// Keeps a pool of child environments in which the URL is already required.
+//
+// [PooledBind.Unbind] returns a proxy (see [Environment.NewProxy]) in which
+// every function call borrows an environment from the pool, calls the same
+// function in it, and then returns it to the pool. Thus different goroutines
+// can call the same function concurrently. Note that each environment has its
+// own module state.
+//
+// Environments are created as needed, up to the pool's size. If the pool is
+// full then calls wait for an environment to be returned, or until
+// [Environment.Timeout]. Safe to share between goroutines.
+//
+// The environments are released by [PooledBind.Close], which is also called
+// when the environment of the context that created the PooledBind is
+// released.
+type PooledBind struct {
+	jsContext  *Context
+	url        exturl.URL
+	exportName string
+	size       int
+
+	idle     chan *pooledBindMember
+	created  int
+	freed    chan struct{} // closed when a reservation is canceled or when closed
+	closed   bool
+	poolLock sync.Mutex
+
+	unregister func() // see: Environment.onRelease
+
+	value   any
+	context *Context
+	unbound bool
+	lock    sync.Mutex
+}
+
+type pooledBindMember struct {
+	value   any
+	context *Context
+}
+
+func (self *pooledBindMember) releaseEnvironment() {
+	if err := self.context.Environment.Release(); err != nil {
+		self.context.Environment.Log.Error(err.Error())
+	}
+}
+
+// Will resolve the id and store the URL and exportName in a [PooledBind]. If
+// size is <= 0 then [runtime.GOMAXPROCS] is used.
+//
+// Unlike the other binds a pointer is returned, because the PooledBind owns
+// the pool, which must not be copied, and has to be closed.
+func (self *Context) NewPooledBind(id string, exportName string, size int) (*PooledBind, error) {
+	context, cancelContext := self.Environment.NewTimeoutContext()
+	defer cancelContext()
+
+	if size <= 0 {
+		size = runtime.GOMAXPROCS(0)
+	}
+
+	if url, err := self.ResolveAndWatch(context, id, false); err == nil {
+		pooledBind := PooledBind{
+			jsContext:  self,
+			url:        url,
+			exportName: exportName,
+			size:       size,
+			idle:       make(chan *pooledBindMember, size),
+			freed:      make(chan struct{}),
+		}
+		pooledBind.unregister = self.Environment.onRelease(pooledBind.Close)
+		return &pooledBind, nil
+	} else {
+		return nil, err
+	}
+}
+
+// ([Bind] interface)
+//
+// Errors are not cached, so a failed call can be retried.
+func (self *PooledBind) Unbind() (any, *Context, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if !self.unbound {
+		// We need one member for the structure of the value
+		if member, err := self.borrow(); err == nil {
+			self.value = self.newPooledValue(member.value, nil, make(map[uintptr]any))
+			self.context = member.context
+			self.unbound = true
+			self.release(member)
+		} else {
+			return nil, nil, err
+		}
+	}
+
+	return self.value, self.context, nil
+}
+
+// Releases the idle environments, and the others when they are returned to
+// the pool. Calls then fail. Can be called more than once.
+func (self *PooledBind) Close() {
+	self.poolLock.Lock()
+	if self.closed {
+		self.poolLock.Unlock()
+		return
+	}
+	self.closed = true
+
+	// Wake up all waiters
+	close(self.freed)
+	self.freed = make(chan struct{})
+	self.poolLock.Unlock()
+
+	self.unregister()
+
+	for {
+		select {
+		case member := <-self.idle:
+			member.releaseEnvironment()
+		default:
+			return
+		}
+	}
+}
+
+func (self *PooledBind) borrow() (*pooledBindMember, error) {
+	context, cancelContext := self.jsContext.Environment.NewTimeoutContext()
+	defer cancelContext()
+
+	for {
+		select {
+		case member := <-self.idle:
+			return member, nil
+		default:
+		}
+
+		reserved, freed, closed := self.reserve()
+		if closed {
+			return nil, fmt.Errorf("pooled bind %s is closed", self.url.String())
+		}
+		if reserved {
+			if member, err := self.newMember(context); err == nil {
+				return member, nil
+			} else {
+				self.unreserve()
+				return nil, err
+			}
+		}
+
+		// The pool is full, so wait for an idle member or for a free slot
+		select {
+		case member := <-self.idle:
+			return member, nil
+		case <-freed:
+		case <-context.Done():
+			return nil, fmt.Errorf("pooled bind %s: %w", self.url.String(), context.Err())
+		}
+	}
+}
+
+func (self *PooledBind) release(member *pooledBindMember) {
+	self.poolLock.Lock()
+	defer self.poolLock.Unlock()
+
+	if self.closed {
+		member.releaseEnvironment()
+	} else {
+		// There is always room for our members
+		self.idle <- member
+	}
+}
+
+// Like [Context.RequireAndProxy], but if it fails the module is not kept in
+// our module's children, because failures can be retried any number of times.
+// (Successful members are kept, but there are at most size of them.)
+func (self *PooledBind) newMember(context contextpkg.Context) (*pooledBindMember, error) {
+	jsContext := self.jsContext.Environment.NewChild().NewContext(self.url, self.jsContext, nil)
+	if exports, err := jsContext.require(context); err == nil {
+		if value, err := jsContext.newExportsProxy(exports, self.exportName); err == nil {
+			return &pooledBindMember{value, jsContext}, nil
+		} else {
+			self.unlinkMember(jsContext)
+			return nil, err
+		}
+	} else {
+		self.unlinkMember(jsContext)
+		return nil, err
+	}
+}
+
+func (self *PooledBind) unlinkMember(jsContext *Context) {
+	module := self.jsContext.Module
+	self.jsContext.Environment.Lock.Lock()
+	module.Children = slices.DeleteFunc(module.Children, func(module *Module) bool {
+		return module == jsContext.Module
+	})
+	self.jsContext.Environment.Lock.Unlock()
+}
+
+// If the pool is full returns false and a channel that will be closed when a
+// slot is freed.
+func (self *PooledBind) reserve() (bool, <-chan struct{}, bool) {
+	self.poolLock.Lock()
+	defer self.poolLock.Unlock()
+
+	if self.closed {
+		return false, nil, true
+	}
+	if self.created < self.size {
+		self.created++
+		return true, nil, false
+	}
+	return false, self.freed, false
+}
+
+func (self *PooledBind) unreserve() {
+	self.poolLock.Lock()
+	defer self.poolLock.Unlock()
+
+	self.created--
+
+	// Wake up all waiters
+	close(self.freed)
+	self.freed = make(chan struct{})
+}
+
+// Copies the structure of a member's value, replacing functions with ones that
+// call the function at the same path in a borrowed member.
+func (self *PooledBind) newPooledValue(value any, path []any, values map[uintptr]any) any {
+	switch value_ := value.(type) {
+	case ProxyFunc:
+		// ProxyFunc signature
+		return func(arguments ...any) (any, error) {
+			if member, err := self.borrow(); err == nil {
+				defer self.release(member)
+
+				if function, ok := lookupPath(member.value, path).(ProxyFunc); ok {
+					return function(arguments...)
+				} else {
+					// Should never happen
+					return nil, fmt.Errorf("not a function in pooled bind: %v", path)
+				}
+			} else {
+				return nil, err
+			}
+		}
+
+	case map[string]any:
+		if value_ == nil {
+			return value_
+		}
+		pointer := reflect.ValueOf(value_).Pointer()
+		if value__, ok := values[pointer]; ok {
+			return value__
+		}
+		pooled := make(map[string]any, len(value_))
+		values[pointer] = pooled
+		for key, element := range value_ {
+			pooled[key] = self.newPooledValue(element, append(slices.Clip(path), key), values)
+		}
+		return pooled
+
+	case []any:
+		if len(value_) == 0 {
+			return value_
+		}
+		pointer := reflect.ValueOf(value_).Pointer()
+		if value__, ok := values[pointer]; ok {
+			return value__
+		}
+		pooled := make([]any, len(value_))
+		values[pointer] = pooled
+		for index, element := range value_ {
+			pooled[index] = self.newPooledValue(element, append(slices.Clip(path), index), values)
+		}
+		return pooled
+
+	default:
+		return value
+	}
+}
+
+func lookupPath(value any, path []any) any {
+	for _, element := range path {
+		switch element_ := element.(type) {
+		case string:
+			if map_, ok := value.(map[string]any); ok {
+				value = map_[element_]
+			} else {
+				return nil
+			}
+
+		case int:
+			if slice, ok := value.([]any); ok && (element_ < len(slice)) {
+				value = slice[element_]
+			} else {
+				return nil
+			}
+		}
+	}
+	return value
+}
@@ -0,0 +1,140 @@
+package commonjs
+
+import (
+	"reflect"
+	"slices"
+	"strconv"
+
+	"github.com/dop251/goja"
+)
+
+// A Go-callable proxy for a JavaScript function that belongs to another
+// [Environment]. See [Environment.NewProxy].
+type ProxyFunc = func(arguments ...any) (any, error)
+
+var (
+	mapType   = reflect.TypeOf(map[string]any(nil))
+	sliceType = reflect.TypeOf([]any(nil))
+)
+
+// Converts a value that belongs to the environment's runtime into a value that
+// can be safely used from other goroutines and runtimes:
+//
+//   - Functions become a [ProxyFunc]. Calling it runs the function via
+//     [Environment.Execute]. Arguments are copied like exported values (see
+//     below) and cloned into the runtime with [StructuredCloneGo], and the
+//     result is converted with NewProxy. Functions that are properties of an
+//     object are called with the object as "this".
+//   - Plain objects and arrays are copied, recursively, into map[string]any and
+//     []any, in the manner of a structured clone. Cycles are preserved.
+//   - Other values are exported (see [goja.Value.Export]). Exported slices
+//     (e.g. of typed arrays) and array buffers are copied, because they would
+//     otherwise share memory with the runtime.
+//
+// Must be called while no one else is using the runtime, e.g. within
+// [Environment.Execute].
+func (self *Environment) NewProxy(value goja.Value) (proxy any, err error) {
+	defer func() {
+		if err_ := HandleJavaScriptPanic(recover()); err_ != nil {
+			err = err_
+		}
+	}()
+
+	return self.newProxy(value, goja.Undefined(), make(map[*goja.Object]any)), nil // can panic
+}
+
+// Like [Environment.NewProxy] but for a property of an object, so that if the
+// property is a function it is called with the object as "this".
+func (self *Environment) NewPropertyProxy(object *goja.Object, name string) (proxy any, err error) {
+	defer func() {
+		if err_ := HandleJavaScriptPanic(recover()); err_ != nil {
+			err = err_
+		}
+	}()
+
+	return self.newProxy(object.Get(name), object, make(map[*goja.Object]any)), nil // can panic
+}
+
+func (self *Environment) newProxy(value goja.Value, this goja.Value, proxies map[*goja.Object]any) any {
+	object, ok := value.(*goja.Object)
+	if !ok {
+		if value == nil {
+			return nil
+		}
+		return value.Export()
+	}
+
+	if proxy, ok := proxies[object]; ok {
+		return proxy
+	}
+
+	if function, ok := goja.AssertFunction(object); ok {
+		proxy := self.newProxyFunc(function, this)
+		proxies[object] = proxy
+		return proxy
+	}
+
+	switch object.ExportType() {
+	case mapType:
+		proxy := make(map[string]any)
+		proxies[object] = proxy
+		for _, key := range object.Keys() {
+			proxy[key] = self.newProxy(object.Get(key), object, proxies) // Get can panic
+		}
+		return proxy
+
+	case sliceType:
+		length := int(object.Get("length").ToInteger())
+		proxy := make([]any, length)
+		proxies[object] = proxy
+		for index := range length {
+			proxy[index] = self.newProxy(object.Get(strconv.Itoa(index)), object, proxies) // Get can panic
+		}
+		return proxy
+
+	default:
+		return copyExported(object.Export())
+	}
+}
+
+// Copies slices and array buffers so that they do not share memory with the
+// runtime.
+func copyExported(value any) any {
+	if arrayBuffer, ok := value.(goja.ArrayBuffer); ok {
+		return slices.Clone(arrayBuffer.Bytes())
+	}
+
+	if value_ := reflect.ValueOf(value); (value_.Kind() == reflect.Slice) && !value_.IsNil() {
+		copy := reflect.MakeSlice(value_.Type(), value_.Len(), value_.Len())
+		reflect.Copy(copy, value_)
+		return copy.Interface()
+	}
+
+	return value
+}
+
+func (self *Environment) newProxyFunc(function goja.Callable, this goja.Value) ProxyFunc {
+	// ProxyFunc signature
+	return func(arguments ...any) (any, error) {
+		var result any
+		err := self.Execute(func() error {
+			arguments_ := make([]goja.Value, len(arguments))
+			for index, argument := range arguments {
+				// The argument might share memory with another runtime
+				if argument_, err := StructuredCloneGo(self.Runtime, copyExported(argument)); err == nil {
+					arguments_[index] = argument_
+				} else {
+					return err
+				}
+			}
+
+			if value, err := function(this, arguments_...); err == nil {
+				result, err = self.NewProxy(value)
+				return err
+			} else {
+				return UnwrapJavaScriptException(err)
+			}
+		})
+		return result, err
+	}
+}
@@ -0,0 +1,87 @@
+package commonjs
+
+import (
+	"slices"
+)
+
+// Tracks what a top-level require changed, so that it can be rolled back if it
+// fails. See [Environment.TransactionalRequire].
+type requireTransaction struct {
+	keys     []string // modules that were not cached when the transaction began
+	children []requireTransactionChild
+}
+
+type requireTransactionChild struct {
+	environment *Environment // of the parent
+	parent      *Module
+	child       *Module
+}
+
+// Returns nil if a transaction is already in progress (in which case the
+// caller is not top-level) or if transactions are disabled.
+func (self *Environment) beginRequireTransaction() *requireTransaction {
+	if !self.TransactionalRequire {
+		return nil
+	}
+
+	self.transactionLock.Lock()
+	defer self.transactionLock.Unlock()
+
+	if self.transaction != nil {
+		return nil
+	}
+
+	self.transaction = new(requireTransaction)
+	return self.transaction
+}
+
+// If the top-level require failed then everything first loaded during it is
+// removed from the exports cache and from [Environment.Modules], and modules
+// created during it are removed from their parents' [Module.Children].
+//
+// Only this environment's state is rolled back. The program cache and the
+// generations are shared with other environments, which might have loaded the
+// same modules successfully (see: Environment.uncacheModule).
+func (self *Environment) endRequireTransaction(transaction *requireTransaction, failed bool) {
+	self.transactionLock.Lock()
+	self.transaction = nil
+	self.transactionLock.Unlock()
+
+	if !failed {
+		return
+	}
+
+	for _, key := range transaction.keys {
+		self.exportsCache.Delete(key)
+		self.Lock.Lock()
+		self.Modules.Delete(key)
+		self.Lock.Unlock()
+	}
+
+	for _, child := range transaction.children {
+		// See: Environment.newContext
+		child.environment.Lock.Lock()
+		child.parent.Children = slices.DeleteFunc(child.parent.Children, func(module *Module) bool {
+			return module == child.child
+		})
+		child.environment.Lock.Unlock()
+	}
+}
+
+func (self *Environment) addToRequireTransaction(key string) {
+	self.transactionLock.Lock()
+	defer self.transactionLock.Unlock()
+
+	if self.transaction != nil {
+		self.transaction.keys = append(self.transaction.keys, key)
+	}
+}
+
+func (self *Environment) addChildToRequireTransaction(parent *Context, child *Module) {
+	self.transactionLock.Lock()
+	defer self.transactionLock.Unlock()
+
+	if self.transaction != nil {
+		self.transaction.children = append(self.transaction.children, requireTransactionChild{parent.Environment, parent.Module, child})
+	}
+}
@@ -0,0 +1,110 @@
+package commonjs_test
+
+import (
+	"testing"
+
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/exturl"
+)
+
+func TestTransactionalRequire(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	environment.TransactionalRequire = true
+
+	var loads int
+	environment.Extensions = []commonjs.Extension{{
+		Name: "count",
+		Create: func(jsContext *commonjs.Context) any {
+			return func() {
+				loads++
+			}
+		},
+	}}
+
+	environment.DefineModuleSource("a", "require('b'); require('c');")
+	environment.DefineModuleSource("b", "count();")
+	environment.DefineModuleSource("c", "throw new Error('c');")
+
+	if _, err := environment.Require("a", false, nil); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	for _, id := range []string{"virtual:a", "virtual:b", "virtual:c"} {
+		if environment.Modules.Get(id) != nil {
+			t.Errorf("not rolled back: %s", id)
+		}
+	}
+
+	environment.DefineModuleSource("c", "")
+
+	if _, err := environment.Require("a", false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if loads != 2 {
+		t.Errorf("expected b to be loaded again: %d", loads)
+	}
+
+	for _, id := range []string{"virtual:a", "virtual:b", "virtual:c"} {
+		if environment.Modules.Get(id) == nil {
+			t.Errorf("not loaded: %s", id)
+		}
+	}
+
+	// A failed require from an already loaded module removes the failed module
+	// from its children
+	environment.DefineModuleSource("lazy", "exports.load = function() { require('d'); };\nexports.children = function() { return module.children.length; };")
+	environment.DefineModuleSource("d", "throw new Error('d');")
+
+	if exports, err := environment.Require("lazy", false, nil); err == nil {
+		if _, err := environment.GetAndCall(exports, "load", nil); err == nil {
+			t.Error("expected an error")
+		}
+
+		if children, err := environment.GetAndCall(exports, "children", nil); err == nil {
+			if children != int64(0) {
+				t.Errorf("not removed from children: %v", children)
+			}
+		} else {
+			t.Error(err)
+		}
+	} else {
+		t.Fatal(err)
+	}
+}
+
+func TestTransactionalRequireInChild(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	environment.TransactionalRequire = true
+
+	environment.DefineModuleSource("lib", "exports.singleton = {};")
+	environment.DefineModuleSource("broken", "require('lib');\nthrow new Error('broken');")
+
+	lib, err := environment.Require("lib", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rolling back the child does not affect the parent
+	if _, err := environment.NewChild().Require("broken", false, nil); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if lib_, err := environment.Require("lib", false, nil); err == nil {
+		if !lib_.Get("singleton").SameAs(lib.Get("singleton")) {
+			t.Error("lib was loaded again in the parent")
+		}
+	} else {
+		t.Fatal(err)
+	}
+}
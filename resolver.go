@@ -2,15 +2,48 @@ package commonjs
 
 import (
 	contextpkg "context"
+	"errors"
+	"io/fs"
 	"path/filepath"
+	"strings"
 
 	"github.com/tliron/exturl"
 )
 
 type ResolveFunc func(context contextpkg.Context, id string, bareId bool) (exturl.URL, error)
 
+// The returned resolver should return an error that wraps [fs.ErrNotExist] or
+// is an [*exturl.NotFound] if the module does not exist, so that it is reported
+// as [ErrNotFound].
 type CreateResolverFunc func(fromUrl exturl.URL, jsContext *Context) ResolveFunc
 
+// Wraps [Environment.CreateResolver] so that virtual modules (see
+// [Environment.DefineModule]) and then native modules (see
+// [Environment.NativeModules]) are resolved first. Errors are returned as [*LoadError] if the module does not exist (see
+// [CreateResolverFunc]) or if the context is done.
+func (self *Environment) newResolver(fromUrl exturl.URL, jsContext *Context) ResolveFunc {
+	resolve := self.CreateResolver(fromUrl, jsContext)
+
+	// ResolveFunc signature
+	return func(context contextpkg.Context, id string, bareId bool) (exturl.URL, error) {
+		if url, ok := self.resolveVirtualModule(id); ok {
+			return url, nil
+		}
+
+		if url, ok := self.resolveNativeModule(id, jsContext); ok {
+			return url, nil
+		}
+
+		if url, err := resolve(context, id, bareId); err == nil {
+			return url, nil
+		} else {
+			return nil, newResolveError(context, err)
+		}
+	}
+}
+
+// Base paths are tried in order. They can include [FSURL] instances, in which
+// case IDs of the form "fs:name!/path" are also supported.
 func NewDefaultResolverCreator(defaultExtension string, allowFilePaths bool, urlContext *exturl.Context, basePaths ...exturl.URL) CreateResolverFunc {
 	// CreateResolverFunc signature
 	return func(fromUrl exturl.URL, jsContext *Context) ResolveFunc {
@@ -19,44 +52,92 @@ func NewDefaultResolverCreator(defaultExtension string, allowFilePaths bool, url
 			basePaths_ = append([]exturl.URL{fromUrl.Base()}, basePaths_...)
 		}
 
-		if defaultExtension == "" {
-			if allowFilePaths {
-				// ResolveFunc signature
-				return func(context contextpkg.Context, id string, bareId bool) (exturl.URL, error) {
-					return urlContext.NewValidAnyOrFileURL(context, id, basePaths_)
-				}
-			} else {
-				// ResolveFunc signature
-				return func(context contextpkg.Context, id string, bareId bool) (exturl.URL, error) {
-					return urlContext.NewValidURL(context, id, basePaths_)
-				}
+		resolve := newDefaultResolver(defaultExtension, allowFilePaths, urlContext, basePaths_)
+
+		// ResolveFunc signature
+		return func(context contextpkg.Context, id string, bareId bool) (exturl.URL, error) {
+			if url, ok, err := resolveFSURL(context, addDefaultExtension(id, bareId, defaultExtension), basePaths_); ok {
+				return url, err
+			}
+
+			return resolve(context, id, bareId)
+		}
+	}
+}
+
+func newDefaultResolver(defaultExtension string, allowFilePaths bool, urlContext *exturl.Context, basePaths []exturl.URL) ResolveFunc {
+	if defaultExtension == "" {
+		if allowFilePaths {
+			// ResolveFunc signature
+			return func(context contextpkg.Context, id string, bareId bool) (exturl.URL, error) {
+				return urlContext.NewValidAnyOrFileURL(context, id, basePaths)
 			}
 		} else {
-			defaultExtension_ := "." + defaultExtension // new var for capture
-
-			if allowFilePaths {
-				// ResolveFunc signature
-				return func(context contextpkg.Context, id string, bareId bool) (exturl.URL, error) {
-					if !bareId {
-						if filepath.Ext(id) == "" {
-							id += defaultExtension_
-						}
-					}
+			// ResolveFunc signature
+			return func(context contextpkg.Context, id string, bareId bool) (exturl.URL, error) {
+				return urlContext.NewValidURL(context, id, basePaths)
+			}
+		}
+	} else {
+		defaultExtension_ := "." + defaultExtension // new var for capture
 
-					return urlContext.NewValidAnyOrFileURL(context, id, basePaths_)
-				}
-			} else {
-				// ResolveFunc signature
-				return func(context contextpkg.Context, id string, bareId bool) (exturl.URL, error) {
-					if !bareId {
-						if filepath.Ext(id) == "" {
-							id += defaultExtension_
-						}
+		if allowFilePaths {
+			// ResolveFunc signature
+			return func(context contextpkg.Context, id string, bareId bool) (exturl.URL, error) {
+				if !bareId {
+					if filepath.Ext(id) == "" {
+						id += defaultExtension_
 					}
+				}
 
-					return urlContext.NewValidURL(context, id, basePaths_)
+				return urlContext.NewValidAnyOrFileURL(context, id, basePaths)
+			}
+		} else {
+			// ResolveFunc signature
+			return func(context contextpkg.Context, id string, bareId bool) (exturl.URL, error) {
+				if !bareId {
+					if filepath.Ext(id) == "" {
+						id += defaultExtension_
+					}
 				}
+
+				return urlContext.NewValidURL(context, id, basePaths)
 			}
 		}
 	}
 }
+
+func addDefaultExtension(id string, bareId bool, defaultExtension string) string {
+	if !bareId && (defaultExtension != "") {
+		if filepath.Ext(id) == "" {
+			id += "." + defaultExtension
+		}
+	}
+	return id
+}
+
+// Errors are "not found" only if the module doesn't exist.
+func newResolveError(context contextpkg.Context, err error) error {
+	if isNotExist(err) {
+		return newLoadError(context, ErrNotFound, err)
+	}
+
+	if context.Err() != nil {
+		// Will become ErrTimeout if the deadline was exceeded
+		return newLoadError(context, ErrInterrupted, err)
+	}
+
+	return err
+}
+
+// exturl reports some of its "not found" failures as plain errors, so we also
+// recognize them by their messages.
+func isNotExist(err error) bool {
+	var notFound *exturl.NotFound
+	if errors.Is(err, fs.ErrNotExist) || errors.As(err, &notFound) {
+		return true
+	}
+
+	message := err.Error()
+	return strings.HasPrefix(message, "invalid URL: ") || strings.HasPrefix(message, "file URL path not found: ")
+}
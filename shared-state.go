@@ -0,0 +1,364 @@
+package commonjs
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/dop251/goja"
+)
+
+//
+// SharedState
+//
+
+// A key-value store that can be used from any runtime, as well as from Go.
+// Values are copied in and out via a [TransferRuntime], so they do not belong
+// to any runtime.
+//
+// Subscribers are notified of changes in the order in which they happened.
+//
+// Safe to share between goroutines and environments.
+type SharedState struct {
+	entries       map[string]sharedStateEntry
+	version       uint64
+	subscriptions map[*sharedStateSubscription]struct{}
+	transfer      *TransferRuntime
+	lock          sync.Mutex
+}
+
+type sharedStateEntry struct {
+	value   goja.Value // in the transfer runtime
+	version uint64     // unique across all keys
+}
+
+type sharedStateChange struct {
+	key      string
+	value    goja.Value // in the transfer runtime
+	oldValue goja.Value // in the transfer runtime
+	deleted  bool
+}
+
+// A change as delivered to Go subscribers.
+type SharedStateChange struct {
+	Key      string
+	Value    any
+	OldValue any
+	Deleted  bool
+}
+
+// Called with the current value (undefined if there is none) and returns the
+// new value.
+type SharedStateUpdateFunc = func(value goja.Value) (goja.Value, error)
+
+func NewSharedState() *SharedState {
+	return &SharedState{
+		entries:       make(map[string]sharedStateEntry),
+		subscriptions: make(map[*sharedStateSubscription]struct{}),
+		transfer:      NewTransferRuntime(),
+	}
+}
+
+// Returns false if the key does not exist. No one else may be using the to
+// runtime during the call.
+func (self *SharedState) Get(to *goja.Runtime, key string) (goja.Value, bool, error) {
+	if entry, ok := self.get(key); ok {
+		value, err := self.transfer.CloneOut(to, entry.value)
+		return value, true, err
+	} else {
+		return goja.Undefined(), false, nil
+	}
+}
+
+// Like [SharedState.Get] but for a Go value.
+func (self *SharedState) GetGo(key string) (any, bool) {
+	if entry, ok := self.get(key); ok {
+		return self.transfer.ToGo(entry.value), true
+	} else {
+		return nil, false
+	}
+}
+
+// No one else may be using the from runtime during the call.
+func (self *SharedState) Set(from *goja.Runtime, key string, value goja.Value) error {
+	if value_, err := self.transfer.CloneIn(from, value); err == nil {
+		self.lock.Lock()
+		defer self.lock.Unlock()
+
+		self.commit(key, value_)
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Like [SharedState.Set] but for a Go value.
+func (self *SharedState) SetGo(key string, value any) error {
+	if value_, err := self.transfer.FromGo(value); err == nil {
+		self.lock.Lock()
+		defer self.lock.Unlock()
+
+		self.commit(key, value_)
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Returns false if the key did not exist.
+func (self *SharedState) Delete(key string) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if entry, ok := self.entries[key]; ok {
+		delete(self.entries, key)
+		self.notify(sharedStateChange{key: key, oldValue: entry.value, deleted: true})
+		return true
+	} else {
+		return false
+	}
+}
+
+func (self *SharedState) Has(key string) bool {
+	_, ok := self.get(key)
+	return ok
+}
+
+// Sorted.
+func (self *SharedState) Keys() []string {
+	self.lock.Lock()
+	keys := make([]string, 0, len(self.entries))
+	for key := range self.entries {
+		keys = append(keys, key)
+	}
+	self.lock.Unlock()
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Sets the value only if the current value is the same as expected, in which
+// case returns true. Primitives are compared in the manner of JavaScript's
+// Object.is, and objects are compared deeply by their own enumerable
+// properties. An expected value of undefined or null also matches a key that
+// does not exist. No one else may be using the runtime during the call.
+func (self *SharedState) CompareAndSwap(runtime *goja.Runtime, key string, expected goja.Value, value goja.Value) (bool, error) {
+	if expected_, err := self.transfer.CloneIn(runtime, expected); err == nil {
+		if value_, err := self.transfer.CloneIn(runtime, value); err == nil {
+			return self.compareAndSwap(key, expected_, value_), nil
+		} else {
+			return false, err
+		}
+	} else {
+		return false, err
+	}
+}
+
+// Like [SharedState.CompareAndSwap] but for Go values. Numbers are compared by
+// value, so e.g. int64(1) and float64(1) are the same.
+func (self *SharedState) CompareAndSwapGo(key string, expected any, value any) (bool, error) {
+	if expected_, err := self.transfer.FromGo(expected); err == nil {
+		if value_, err := self.transfer.FromGo(value); err == nil {
+			return self.compareAndSwap(key, expected_, value_), nil
+		} else {
+			return false, err
+		}
+	} else {
+		return false, err
+	}
+}
+
+// Atomically replaces the value with the one returned by the update function
+// and returns it. The update function is called without locking, so it may be
+// called again if another change to the key happened in the meantime. No one
+// else may be using the runtime during the call.
+func (self *SharedState) Update(runtime *goja.Runtime, key string, update SharedStateUpdateFunc) (goja.Value, error) {
+	for {
+		entry, _ := self.get(key)
+
+		value := goja.Undefined()
+		if entry.value != nil {
+			var err error
+			if value, err = self.transfer.CloneOut(runtime, entry.value); err != nil {
+				return nil, err
+			}
+		}
+
+		if value, err := update(value); err == nil {
+			if value_, err := self.transfer.CloneIn(runtime, value); err == nil {
+				if self.commitIfVersion(key, entry.version, value_) {
+					return value, nil
+				}
+			} else {
+				return nil, err
+			}
+		} else {
+			return nil, err
+		}
+	}
+}
+
+// Like [SharedState.Update] but for Go values. The update function is called
+// with nil if there is no value.
+func (self *SharedState) UpdateGo(key string, update func(value any) (any, error)) (any, error) {
+	for {
+		entry, _ := self.get(key)
+
+		if value, err := update(self.transfer.ToGo(entry.value)); err == nil {
+			if value_, err := self.transfer.FromGo(value); err == nil {
+				if self.commitIfVersion(key, entry.version, value_) {
+					return value, nil
+				}
+			} else {
+				return nil, err
+			}
+		} else {
+			return nil, err
+		}
+	}
+}
+
+// Calls the function for every change of the key, or of all keys if key is
+// empty. Changes are delivered on a separate goroutine. Returns a function
+// that unsubscribes.
+func (self *SharedState) SubscribeGo(key string, notify func(change SharedStateChange)) func() {
+	return self.subscribe(key, func(change sharedStateChange) {
+		notify(SharedStateChange{
+			Key:      change.key,
+			Value:    self.transfer.ToGo(change.value),
+			OldValue: self.transfer.ToGo(change.oldValue),
+			Deleted:  change.deleted,
+		})
+	})
+}
+
+// Like [SharedState.SubscribeGo] but calls a JavaScript function via
+// [Environment.Execute] (see it for what that requires of other code using the
+// runtime) with an object with "key", "value", "oldValue", and "deleted".
+// Errors are logged. The subscription ends when the environment is released.
+func (self *SharedState) Subscribe(environment *Environment, key string, notify goja.Callable) func() {
+	unsubscribe := self.subscribe(key, func(change sharedStateChange) {
+		if err := environment.Execute(func() error {
+			runtime := environment.Runtime
+			event := runtime.NewObject()
+			event.Set("key", change.key)
+			event.Set("deleted", change.deleted)
+
+			for name, value := range map[string]goja.Value{"value": change.value, "oldValue": change.oldValue} {
+				if value == nil {
+					event.Set(name, goja.Undefined())
+				} else if value_, err := self.transfer.CloneOut(runtime, value); err == nil {
+					event.Set(name, value_)
+				} else {
+					return err
+				}
+			}
+
+			_, err := notify(nil, event)
+			return UnwrapJavaScriptException(err)
+		}); err != nil {
+			environment.Log.Errorf("shared state subscription for %q: %s", change.key, err.Error())
+		}
+	})
+
+	unregister := environment.onRelease(unsubscribe)
+	return func() {
+		unregister()
+		unsubscribe()
+	}
+}
+
+func (self *SharedState) get(key string) (sharedStateEntry, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	entry, ok := self.entries[key]
+	return entry, ok
+}
+
+func (self *SharedState) compareAndSwap(key string, expected goja.Value, value goja.Value) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if entry, ok := self.entries[key]; ok {
+		if !self.transfer.same(entry.value, expected) {
+			return false
+		}
+	} else if (expected != nil) && !goja.IsUndefined(expected) && !goja.IsNull(expected) {
+		return false
+	}
+
+	self.commit(key, value)
+	return true
+}
+
+// Version 0 means that the key must not exist.
+func (self *SharedState) commitIfVersion(key string, version uint64, value goja.Value) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.entries[key].version != version {
+		return false
+	}
+
+	self.commit(key, value)
+	return true
+}
+
+// Must be called while locked.
+func (self *SharedState) commit(key string, value goja.Value) {
+	self.version++
+	oldValue := self.entries[key].value
+	self.entries[key] = sharedStateEntry{value, self.version}
+	self.notify(sharedStateChange{key: key, value: value, oldValue: oldValue})
+}
+
+// Must be called while locked.
+func (self *SharedState) notify(change sharedStateChange) {
+	for subscription := range self.subscriptions {
+		if (subscription.key == "") || (subscription.key == change.key) {
+			subscription.changes.push(change)
+		}
+	}
+}
+
+func (self *SharedState) subscribe(key string, notify func(change sharedStateChange)) func() {
+	subscription := sharedStateSubscription{
+		key:     key,
+		changes: newEventQueue[sharedStateChange](),
+		done:    make(chan struct{}),
+	}
+
+	self.lock.Lock()
+	self.subscriptions[&subscription] = struct{}{}
+	self.lock.Unlock()
+
+	go func() {
+		for {
+			if change, ok := subscription.changes.pop(subscription.done); ok {
+				notify(change)
+			} else {
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			self.lock.Lock()
+			delete(self.subscriptions, &subscription)
+			self.lock.Unlock()
+			close(subscription.done)
+		})
+	}
+}
+
+//
+// sharedStateSubscription
+//
+
+type sharedStateSubscription struct {
+	key     string
+	changes *eventQueue[sharedStateChange]
+	done    chan struct{}
+}
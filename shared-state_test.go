@@ -0,0 +1,203 @@
+package commonjs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/commonjs-goja/api"
+	"github.com/tliron/exturl"
+)
+
+func TestSharedState(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string // has "namespace", must set "ok"
+	}{
+		{
+			name: "copy",
+			source: `
+const config = {a: [1]};
+namespace.set('config', config);
+config.a[0] = 2;
+const value = namespace.get('config');
+value.a[0] = 3;
+ok = namespace.get('config').a[0] === 1;`,
+		},
+		{
+			name: "delete",
+			source: `
+namespace.set('key', 1);
+const deleted = [namespace.delete('key'), namespace.delete('key')];
+ok = deleted[0] && !deleted[1] && !namespace.has('key') && (namespace.get('key') === undefined);`,
+		},
+		{
+			name: "keys",
+			source: `
+namespace.set('b', 1);
+namespace.set('a', 1);
+ok = namespace.keys().join() === 'a,b';`,
+		},
+		{
+			name:   "compare and swap",
+			source: `ok = namespace.compareAndSwap('key', undefined, 0) && !namespace.compareAndSwap('key', undefined, 0) && (namespace.get('key') === 0);`,
+		},
+		{
+			name: "compare and swap numbers",
+			source: `
+namespace.set('zero', -0);
+namespace.set('nan', NaN);
+namespace.set('big', 10n);
+ok = !namespace.compareAndSwap('zero', 0, 1) && namespace.compareAndSwap('zero', -0, 1) &&
+	namespace.compareAndSwap('nan', NaN, 1) &&
+	!namespace.compareAndSwap('big', 10, 1) && namespace.compareAndSwap('big', 10n, 1);`,
+		},
+		{
+			name: "compare and swap objects",
+			source: `
+namespace.set('object', {a: [1, 2], b: 'b'});
+ok = !namespace.compareAndSwap('object', {a: [1], b: 'b'}, 1) &&
+	!namespace.compareAndSwap('object', {a: [1, , 2], b: 'b'}, 1) &&
+	namespace.compareAndSwap('object', {b: 'b', a: [1, 2]}, 1);`,
+		},
+		{
+			name: "update",
+			source: `
+const incrementers = concurrent.waitGroup('incrementers');
+incrementers.add(2);
+const workers = [new Worker('incrementer'), new Worker('incrementer')];
+const waited = incrementers.wait(5);
+workers.forEach(worker => worker.terminate());
+ok = waited && (namespace.get('count') === 200);`,
+		},
+		{
+			name: "delete namespace",
+			source: `
+namespace.set('key', 1);
+state.delete('namespace');
+ok = (namespace.get('key') === 1) && !state.namespace('namespace').has('key');`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			urlContext := exturl.NewContext()
+			defer urlContext.Release()
+
+			environment := commonjs.NewEnvironment(urlContext)
+			defer environment.Release()
+
+			environment.Extensions = api.DefaultExtensions{}.Create()
+
+			environment.DefineModuleSource("incrementer", `
+const namespace = state.namespace('namespace');
+for (let i = 0; i < 100; i++)
+	namespace.update('count', count => (count || 0) + 1);
+concurrent.waitGroup('incrementers').done();`)
+			environment.DefineModuleSource("main", "const namespace = state.namespace('namespace');\nlet ok = false;\n"+test.source+"\nexports.ok = ok;")
+
+			if exports, err := environment.Require("main", false, nil); err == nil {
+				if !exports.Get("ok").ToBoolean() {
+					t.Error("state API did not behave")
+				}
+			} else {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSharedStateSubscriptions(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	environment.Extensions = api.DefaultExtensions{}.Create()
+
+	sharedState := api.GetSharedState(environment, "namespace")
+
+	if api.GetSharedState(environment.NewChild(), "namespace") != sharedState {
+		t.Error("shared state is not shared with children")
+	}
+
+	counts := make(chan any, 1000)
+	defer sharedState.SubscribeGo("count", func(change commonjs.SharedStateChange) {
+		counts <- change.Value
+	})()
+
+	// Changes are delivered in order
+	for count := range 100 {
+		if err := sharedState.SetGo("count", count); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for expected := range int64(100) {
+		select {
+		case count := <-counts:
+			if count != expected {
+				t.Fatalf("unexpected change: %v instead of %d", count, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no change for %d", expected)
+		}
+	}
+
+	// Numbers are compared by value
+	if err := sharedState.SetGo("number", int64(2)); err != nil {
+		t.Fatal(err)
+	}
+	if swapped, err := sharedState.CompareAndSwapGo("number", float64(2), map[string]any{"a": []any{int64(1)}}); (err != nil) || !swapped {
+		t.Errorf("float64 is not the same as int64: %v", err)
+	}
+	if swapped, err := sharedState.CompareAndSwapGo("number", map[string]any{"a": []any{1.0}}, 3); (err != nil) || !swapped {
+		t.Errorf("objects are not the same: %v", err)
+	}
+
+	// Go to JavaScript to Go
+	seen := make(chan any, 10)
+	defer sharedState.SubscribeGo("seen", func(change commonjs.SharedStateChange) {
+		seen <- change.Value
+	})()
+
+	environment.DefineModuleSource("subscriber", `
+const namespace = state.namespace('namespace');
+namespace.subscribe('flag', event => {
+	namespace.set('seen', event.value);
+});`)
+
+	if _, err := environment.Require("subscriber", false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sharedState.SetGo("flag", map[string]any{"b": "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case value := <-seen:
+		if value_, ok := value.(map[string]any); !ok || (value_["b"] != "hello") {
+			t.Errorf("unexpected seen: %v", value)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("JavaScript subscriber was not notified")
+	}
+
+	// Releasing the environment unsubscribes
+	if err := environment.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sharedState.SetGo("flag", "again"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case value := <-seen:
+		t.Errorf("JavaScript subscriber was notified after release: %v", value)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// And drops the shared state
+	if api.GetSharedState(environment, "namespace") == sharedState {
+		t.Error("shared state was not dropped")
+	}
+}
@@ -0,0 +1,77 @@
+package commonjs
+
+import (
+	contextpkg "context"
+	"encoding/base64"
+	"fmt"
+	neturlpkg "net/url"
+	"strings"
+
+	"github.com/go-sourcemap/sourcemap"
+	"github.com/tliron/exturl"
+)
+
+const sourceMappingURLPrefix = "//# sourceMappingURL="
+
+// Reads the source map referenced by a "//# sourceMappingURL=" comment on the
+// last non-empty line of the script. The reference can be an inline "data:"
+// URL or a URL (or path) relative to the module's URL.
+//
+// Returns nil if there is no reference.
+func (self *Environment) readSourceMap(context contextpkg.Context, url exturl.URL, script string) ([]byte, error) {
+	reference, ok := sourceMappingURL(script)
+	if !ok {
+		return nil, nil
+	}
+
+	if data, ok := strings.CutPrefix(reference, "data:"); ok {
+		return decodeDataURL(data)
+	}
+
+	var bases []exturl.URL
+	if url != nil {
+		bases = []exturl.URL{url.Base()}
+	}
+
+	if url_, err := self.URLContext.NewValidURL(context, reference, bases); err == nil {
+		return exturl.ReadBytes(context, url_)
+	} else {
+		return nil, err
+	}
+}
+
+// Source maps apply to the original script, so they are attached to the file
+// after the wrapper's positions have been shifted (see [parseWrappedModule]).
+func newSourceMap(name string, data []byte) (*sourcemap.Consumer, error) {
+	if sourceMap, err := sourcemap.Parse(name, data); err == nil {
+		return sourceMap, nil
+	} else {
+		return nil, fmt.Errorf("could not parse source map for %s: %w", name, err)
+	}
+}
+
+func sourceMappingURL(script string) (string, bool) {
+	script = strings.TrimRight(script, " \t\r\n")
+	line := script[strings.LastIndexByte(script, '\n')+1:]
+	if reference, ok := strings.CutPrefix(strings.TrimSpace(line), sourceMappingURLPrefix); ok {
+		if reference = strings.TrimSpace(reference); reference != "" {
+			return reference, true
+		}
+	}
+	return "", false
+}
+
+// Expects the part after "data:", e.g. "application/json;base64,...".
+func decodeDataURL(data string) ([]byte, error) {
+	if header, content, ok := strings.Cut(data, ","); ok {
+		if strings.HasSuffix(header, ";base64") {
+			return base64.StdEncoding.DecodeString(content)
+		} else if content_, err := neturlpkg.PathUnescape(content); err == nil {
+			return []byte(content_), nil
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("malformed data URL: %s", data)
+	}
+}
@@ -0,0 +1,59 @@
+package commonjs_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/exturl"
+)
+
+// Maps the third generated line to the first line of "original.ts"
+const testSourceMap = `{"version":3,"sources":["original.ts"],"names":[],"mappings":";;AAAA,KAAK,MAAM;"}`
+
+func TestSourceMap(t *testing.T) {
+	tests := []struct {
+		name                    string
+		precompile              commonjs.PrecompileFunc
+		precompileWithSourceMap commonjs.PrecompileWithSourceMapFunc
+	}{
+		{
+			name: "comment",
+			precompile: func(url exturl.URL, script string, jsContext *commonjs.Context) (string, error) {
+				return "// generated\n\n" + script + "\n//# sourceMappingURL=data:application/json;base64," + base64.StdEncoding.EncodeToString([]byte(testSourceMap)), nil
+			},
+		},
+		{
+			name: "returned",
+			precompileWithSourceMap: func(url exturl.URL, script string, jsContext *commonjs.Context) (string, []byte, error) {
+				return "// generated\n\n" + script, []byte(testSourceMap), nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			urlContext := exturl.NewContext()
+			defer urlContext.Release()
+
+			environment := commonjs.NewEnvironment(urlContext)
+			defer environment.Release()
+
+			environment.Precompile = test.precompile
+			environment.PrecompileWithSourceMap = test.precompileWithSourceMap
+
+			environment.DefineModuleSource("main", "null.property;")
+
+			_, err := environment.Require("main", false, nil)
+			var jsError *commonjs.JavaScriptError
+			if errors.As(err, &jsError) {
+				if frame := jsError.Frame(); (frame == nil) || (frame.Line != 1) {
+					t.Errorf("unexpected frame: %v", frame)
+				}
+			} else {
+				t.Errorf("not a JavaScriptError: %T %v", err, err)
+			}
+		})
+	}
+}
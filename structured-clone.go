@@ -0,0 +1,612 @@
+package commonjs
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// Deep copies a value from one runtime to another (they can be the same
+// runtime), in the manner of JavaScript's
+// [structuredClone](https://developer.mozilla.org/en-US/docs/Web/API/structuredClone).
+//
+// Supported are primitives, plain objects, arrays, Date, RegExp, Map, Set,
+// ArrayBuffer, typed arrays, DataView, errors, and the Boolean, Number, and
+// String wrappers. Cycles and shared references are preserved. Only own
+// enumerable string-keyed properties of plain objects are copied.
+//
+// Functions, symbols, and other values cannot be cloned and result in a
+// [*DataCloneError].
+//
+// Neither runtime may be used by anyone else during the call.
+func StructuredClone(from *goja.Runtime, to *goja.Runtime, value goja.Value) (clone goja.Value, err error) {
+	defer func() {
+		if err_ := HandleJavaScriptPanic(recover()); err_ != nil {
+			err = err_
+		}
+	}()
+
+	cloner := newStructuredCloner(from, to)
+	clone, err = cloner.clone(value) // can panic
+	return clone, UnwrapJavaScriptException(err)
+}
+
+// Like [StructuredClone] but from a Go value, which is deep copied, so that
+// the clone does not share memory with it:
+//
+//   - Maps become plain objects if their keys are strings, and Map otherwise.
+//   - Slices and arrays become arrays, except for bytes, which become
+//     Uint8Array.
+//   - [time.Time] becomes a Date.
+//   - Structs and pointers to structs are deep copied (except for unexported
+//     fields), and the copy is converted with [goja.Runtime.ToValue], so that
+//     their fields and methods are available. Other pointers are dereferenced.
+//   - Scalars and functions are converted with [goja.Runtime.ToValue].
+//
+// Cycles and shared references are preserved.
+//
+// Values that belong to a runtime ([goja.Value] and [ExportedJavaScriptFunc]),
+// as well as channels, cannot be cloned and result in a [*DataCloneError].
+//
+// The runtime may not be used by anyone else during the call.
+func StructuredCloneGo(to *goja.Runtime, value any) (clone goja.Value, err error) {
+	defer func() {
+		if err_ := HandleJavaScriptPanic(recover()); err_ != nil {
+			err = err_
+		}
+	}()
+
+	cloner := newStructuredCloner(nil, to)
+	clone, err = cloner.cloneGo(value) // can panic
+	return clone, UnwrapJavaScriptException(err)
+}
+
+//
+// DataCloneError
+//
+
+type DataCloneError struct {
+	Message string
+}
+
+// ([error] interface)
+func (self *DataCloneError) Error() string {
+	return self.Message
+}
+
+// Installs "structuredClone" as a global in the runtime. Errors are thrown as
+// "DataCloneError".
+//
+// Called by [NewEnvironment].
+func InstallStructuredClone(runtime *goja.Runtime) {
+	runtime.Set("structuredClone", func(call goja.FunctionCall) goja.Value {
+		if clone, err := StructuredClone(runtime, runtime, call.Argument(0)); err == nil {
+			return clone
+		} else {
+			var dataCloneError *DataCloneError
+			if errors.As(err, &dataCloneError) {
+				exception := runtime.NewGoError(dataCloneError)
+				exception.Set("name", "DataCloneError")
+				panic(exception)
+			}
+
+			// Rethrow what was thrown while cloning, e.g. by a getter
+			var jsError *JavaScriptError
+			if errors.As(err, &jsError) && (jsError.Exception != nil) {
+				panic(jsError.Exception)
+			}
+
+			panic(runtime.NewGoError(err))
+		}
+	})
+}
+
+//
+// structuredCloner
+//
+
+type structuredCloner struct {
+	from     *goja.Runtime
+	to       *goja.Runtime
+	clones   map[*goja.Object]*goja.Object
+	goClones map[goCloneKey]*goja.Object // see: cloneGo
+}
+
+type goCloneKey struct {
+	pointer uintptr
+	type_   reflect.Type
+	length  int // for slices, which can share a pointer
+}
+
+func newStructuredCloner(from *goja.Runtime, to *goja.Runtime) *structuredCloner {
+	return &structuredCloner{
+		from:     from,
+		to:       to,
+		clones:   make(map[*goja.Object]*goja.Object),
+		goClones: make(map[goCloneKey]*goja.Object),
+	}
+}
+
+var errorConstructorNames = []string{"EvalError", "RangeError", "ReferenceError", "SyntaxError", "TypeError", "URIError"}
+
+// Can panic.
+func (self *structuredCloner) clone(value goja.Value) (goja.Value, error) {
+	if (value == nil) || goja.IsUndefined(value) || goja.IsNull(value) {
+		return value, nil
+	}
+
+	object, ok := value.(*goja.Object)
+	if !ok {
+		if _, ok := value.(*goja.Symbol); ok {
+			return nil, &DataCloneError{fmt.Sprintf("symbol cannot be cloned: %s", value.String())}
+		}
+
+		// Primitives
+		return self.to.ToValue(value.Export()), nil
+	}
+
+	if clone, ok := self.clones[object]; ok {
+		return clone, nil
+	}
+
+	if _, ok := goja.AssertFunction(object); ok {
+		return nil, &DataCloneError{fmt.Sprintf("function cannot be cloned: %s", value.String())}
+	}
+
+	switch object.ClassName() {
+	case "Array":
+		return self.cloneArray(object)
+
+	case "Date":
+		return self.construct(object, "Date", self.call(object, "getTime"))
+
+	case "RegExp":
+		return self.construct(object, "RegExp", object.Get("source"), object.Get("flags"))
+
+	case "Boolean", "Number", "String":
+		return self.construct(object, object.ClassName(), self.call(object, "valueOf"))
+
+	case "Error":
+		return self.cloneError(object)
+	}
+
+	if self.instanceOf(object, "Map") {
+		return self.cloneCollection(object, "Map")
+	}
+
+	if self.instanceOf(object, "Set") {
+		return self.cloneCollection(object, "Set")
+	}
+
+	if self.instanceOf(object, "ArrayBuffer") {
+		return self.cloneArrayBuffer(object)
+	}
+
+	if self.instanceOf(object, "DataView") || self.isTypedArray(object) {
+		return self.cloneView(object)
+	}
+
+	if self.instanceOf(object, "Error") {
+		return self.cloneError(object)
+	}
+
+	if object.ClassName() == "Object" {
+		return self.cloneObject(object)
+	}
+
+	return nil, &DataCloneError{fmt.Sprintf("%s cannot be cloned", object.ClassName())}
+}
+
+// Can panic.
+func (self *structuredCloner) cloneGo(value any) (goja.Value, error) {
+	switch value_ := value.(type) {
+	case nil:
+		return goja.Null(), nil
+
+	case goja.Value, ExportedJavaScriptFunc:
+		return nil, &DataCloneError{fmt.Sprintf("value belongs to a JavaScript runtime and cannot be cloned: %T", value)}
+
+	case goja.ArrayBuffer:
+		// Exported from a runtime
+		return self.to.ToValue(self.to.NewArrayBuffer(slices.Clone(value_.Bytes()))), nil
+
+	case time.Time:
+		return self.to.New(self.to.Get("Date"), self.to.ToValue(value_.UnixMilli()))
+	}
+
+	value_ := reflect.ValueOf(value)
+	switch value_.Kind() {
+	case reflect.Pointer:
+		if value_.IsNil() {
+			return goja.Null(), nil
+		}
+		if value_.Elem().Kind() == reflect.Struct {
+			return self.to.ToValue(copyGo(value_, make(map[uintptr]reflect.Value)).Interface()), nil
+		}
+		return self.cloneGo(value_.Elem().Interface())
+
+	case reflect.Map:
+		if value_.IsNil() {
+			return goja.Null(), nil
+		}
+		return self.cloneGoMap(value_)
+
+	case reflect.Slice:
+		if value_.IsNil() {
+			return goja.Null(), nil
+		}
+		return self.cloneGoList(value_)
+
+	case reflect.Array:
+		return self.cloneGoList(value_)
+
+	case reflect.Struct:
+		return self.to.ToValue(copyGo(value_, make(map[uintptr]reflect.Value)).Interface()), nil
+
+	case reflect.Chan, reflect.UnsafePointer:
+		return nil, &DataCloneError{fmt.Sprintf("%T cannot be cloned", value)}
+
+	default:
+		// Immutable scalars (and functions)
+		return self.to.ToValue(value), nil
+	}
+}
+
+// Maps with string keys become plain objects and other maps become Map.
+//
+// Can panic.
+func (self *structuredCloner) cloneGoMap(value reflect.Value) (goja.Value, error) {
+	key := goCloneKey{value.Pointer(), value.Type(), 0}
+	if clone, ok := self.goClones[key]; ok {
+		return clone, nil
+	}
+
+	keys := value.MapKeys()
+	slices.SortFunc(keys, func(a reflect.Value, b reflect.Value) int {
+		return strings.Compare(fmt.Sprint(a.Interface()), fmt.Sprint(b.Interface()))
+	})
+
+	if value.Type().Key().Kind() == reflect.String {
+		clone := self.to.NewObject()
+		self.goClones[key] = clone
+		for _, key := range keys {
+			if element, err := self.cloneGo(value.MapIndex(key).Interface()); err == nil {
+				clone.Set(key.String(), element)
+			} else {
+				return nil, err
+			}
+		}
+		return clone, nil
+	}
+
+	if clone, err := self.to.New(self.to.Get("Map")); err == nil {
+		self.goClones[key] = clone
+		set := self.method(clone, "set")
+		for _, key := range keys {
+			if key_, err := self.cloneGo(key.Interface()); err == nil {
+				if element, err := self.cloneGo(value.MapIndex(key).Interface()); err == nil {
+					if _, err := set(key_, element); err != nil {
+						return nil, err
+					}
+				} else {
+					return nil, err
+				}
+			} else {
+				return nil, err
+			}
+		}
+		return clone, nil
+	} else {
+		return nil, err
+	}
+}
+
+// Slices and arrays become arrays, except for bytes, which become Uint8Array.
+//
+// Can panic.
+func (self *structuredCloner) cloneGoList(value reflect.Value) (goja.Value, error) {
+	length := value.Len()
+
+	// Only slices can be shared, and empty slices can share a pointer
+	var key goCloneKey
+	if (value.Kind() == reflect.Slice) && (length > 0) {
+		key = goCloneKey{value.Pointer(), value.Type(), length}
+		if clone, ok := self.goClones[key]; ok {
+			return clone, nil
+		}
+	}
+
+	if value.Type().Elem().Kind() == reflect.Uint8 {
+		bytes := make([]byte, length)
+		reflect.Copy(reflect.ValueOf(bytes), value)
+		if clone, err := self.to.New(self.to.Get("Uint8Array"), self.to.ToValue(self.to.NewArrayBuffer(bytes))); err == nil {
+			if key.pointer != 0 {
+				self.goClones[key] = clone
+			}
+			return clone, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	clone := self.to.NewArray()
+	if key.pointer != 0 {
+		self.goClones[key] = clone
+	}
+	for index := range length {
+		if element, err := self.cloneGo(value.Index(index).Interface()); err == nil {
+			clone.Set(strconv.Itoa(index), element)
+		} else {
+			return nil, err
+		}
+	}
+	return clone, nil
+}
+
+func (self *structuredCloner) cloneObject(object *goja.Object) (goja.Value, error) {
+	clone := self.to.NewObject()
+	self.clones[object] = clone
+
+	for _, key := range object.Keys() {
+		if value, err := self.clone(object.Get(key)); err == nil {
+			clone.Set(key, value)
+		} else {
+			return nil, err
+		}
+	}
+
+	return clone, nil
+}
+
+func (self *structuredCloner) cloneArray(object *goja.Object) (goja.Value, error) {
+	clone := self.to.NewArray()
+	self.clones[object] = clone
+
+	clone.Set("length", object.Get("length"))
+	for _, key := range object.Keys() {
+		if value, err := self.clone(object.Get(key)); err == nil {
+			clone.Set(key, value)
+		} else {
+			return nil, err
+		}
+	}
+
+	return clone, nil
+}
+
+func (self *structuredCloner) cloneCollection(object *goja.Object, constructorName string) (goja.Value, error) {
+	clone, err := self.to.New(self.to.Get(constructorName))
+	if err != nil {
+		return nil, err
+	}
+	self.clones[object] = clone
+
+	var add func(arguments ...goja.Value) (goja.Value, error)
+	if constructorName == "Map" {
+		add = self.method(clone, "set")
+	} else {
+		add = self.method(clone, "add")
+	}
+
+	var err_ error
+	self.call(object, "forEach", self.from.ToValue(func(call goja.FunctionCall) goja.Value {
+		if err_ != nil {
+			return goja.Undefined()
+		}
+
+		var value, key goja.Value
+		if value, err_ = self.clone(call.Argument(0)); err_ != nil {
+			return goja.Undefined()
+		}
+
+		if constructorName == "Map" {
+			if key, err_ = self.clone(call.Argument(1)); err_ == nil {
+				_, err_ = add(key, value)
+			}
+		} else {
+			_, err_ = add(value)
+		}
+
+		return goja.Undefined()
+	}))
+
+	if err_ == nil {
+		return clone, nil
+	} else {
+		return nil, err_
+	}
+}
+
+func (self *structuredCloner) cloneArrayBuffer(object *goja.Object) (goja.Value, error) {
+	if arrayBuffer, ok := object.Export().(goja.ArrayBuffer); ok {
+		bytes := append([]byte(nil), arrayBuffer.Bytes()...)
+		clone := self.to.ToValue(self.to.NewArrayBuffer(bytes)).(*goja.Object)
+		self.clones[object] = clone
+		return clone, nil
+	} else {
+		return nil, &DataCloneError{"ArrayBuffer cannot be cloned"}
+	}
+}
+
+// Typed arrays and DataView.
+func (self *structuredCloner) cloneView(object *goja.Object) (goja.Value, error) {
+	constructorName := object.GetSymbol(goja.SymToStringTag).String()
+	if constructorName == "" {
+		return nil, &DataCloneError{"view cannot be cloned"}
+	}
+
+	// The buffer might be shared with other views
+	if buffer, err := self.clone(object.Get("buffer")); err == nil {
+		length := object.Get("length")
+		if constructorName == "DataView" {
+			length = object.Get("byteLength")
+		}
+		return self.construct(object, constructorName, buffer, object.Get("byteOffset"), length)
+	} else {
+		return nil, err
+	}
+}
+
+func (self *structuredCloner) cloneError(object *goja.Object) (goja.Value, error) {
+	name := getString(object, "name")
+
+	constructorName := "Error"
+	for _, errorConstructorName := range errorConstructorNames {
+		if name == errorConstructorName {
+			constructorName = name
+			break
+		}
+	}
+
+	if clone, err := self.construct(object, constructorName, self.to.ToValue(getString(object, "message"))); err == nil {
+		clone_ := clone.(*goja.Object)
+
+		if name != constructorName {
+			clone_.Set("name", name)
+		}
+
+		if stack := object.Get("stack"); stack != nil {
+			clone_.Set("stack", stack.String())
+		}
+
+		if cause := object.Get("cause"); (cause != nil) && !goja.IsUndefined(cause) {
+			if cause_, err := self.clone(cause); err == nil {
+				clone_.Set("cause", cause_)
+			} else {
+				return nil, err
+			}
+		}
+
+		return clone_, nil
+	} else {
+		return nil, err
+	}
+}
+
+func (self *structuredCloner) construct(object *goja.Object, constructorName string, arguments ...goja.Value) (goja.Value, error) {
+	// Arguments are primitives, or have already been cloned into the target runtime
+	for index, argument := range arguments {
+		if _, ok := argument.(*goja.Object); !ok && (argument != nil) {
+			arguments[index] = self.to.ToValue(argument.Export())
+		}
+	}
+
+	if clone, err := self.to.New(self.to.Get(constructorName), arguments...); err == nil {
+		self.clones[object] = clone
+		return clone, nil
+	} else {
+		return nil, err
+	}
+}
+
+func (self *structuredCloner) instanceOf(object *goja.Object, constructorName string) bool {
+	if constructor, ok := self.from.Get(constructorName).(*goja.Object); ok {
+		return self.from.InstanceOf(object, constructor)
+	}
+	return false
+}
+
+func (self *structuredCloner) isTypedArray(object *goja.Object) bool {
+	// %TypedArray% is the prototype of all typed array constructors
+	if uint8Array, ok := self.from.Get("Uint8Array").(*goja.Object); ok {
+		if typedArray := uint8Array.Prototype(); typedArray != nil {
+			return self.from.InstanceOf(object, typedArray)
+		}
+	}
+	return false
+}
+
+// Can panic.
+func (self *structuredCloner) call(object *goja.Object, name string, arguments ...goja.Value) goja.Value {
+	if value, err := self.method(object, name)(arguments...); err == nil {
+		return value
+	} else {
+		panic(err)
+	}
+}
+
+func (self *structuredCloner) method(object *goja.Object, name string) func(arguments ...goja.Value) (goja.Value, error) {
+	if function, ok := goja.AssertFunction(object.Get(name)); ok {
+		return func(arguments ...goja.Value) (goja.Value, error) {
+			return function(object, arguments...)
+		}
+	} else {
+		return func(arguments ...goja.Value) (goja.Value, error) {
+			return nil, fmt.Errorf("not a function: %s", name)
+		}
+	}
+}
+
+// Deep copies a Go value, so that wrapping the copy with
+// [goja.Runtime.ToValue] does not share memory with the value. Unexported
+// fields are copied shallowly. Cycles are preserved via pointers.
+func copyGo(value reflect.Value, copies map[uintptr]reflect.Value) reflect.Value {
+	switch value.Kind() {
+	case reflect.Pointer:
+		if value.IsNil() {
+			return value
+		}
+		if copy, ok := copies[value.Pointer()]; ok {
+			return copy
+		}
+		copy := reflect.New(value.Type().Elem())
+		copies[value.Pointer()] = copy
+		copy.Elem().Set(copyGo(value.Elem(), copies))
+		return copy
+
+	case reflect.Interface:
+		if value.IsNil() {
+			return value
+		}
+		copy := reflect.New(value.Type()).Elem()
+		copy.Set(copyGo(value.Elem(), copies))
+		return copy
+
+	case reflect.Struct:
+		copy := reflect.New(value.Type()).Elem()
+		copy.Set(value)
+		for index := range value.NumField() {
+			if field := copy.Field(index); field.CanSet() {
+				field.Set(copyGo(value.Field(index), copies))
+			}
+		}
+		return copy
+
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+		copy := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for index := range value.Len() {
+			copy.Index(index).Set(copyGo(value.Index(index), copies))
+		}
+		return copy
+
+	case reflect.Array:
+		copy := reflect.New(value.Type()).Elem()
+		for index := range value.Len() {
+			copy.Index(index).Set(copyGo(value.Index(index), copies))
+		}
+		return copy
+
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+		copy := reflect.MakeMapWithSize(value.Type(), value.Len())
+		iterator := value.MapRange()
+		for iterator.Next() {
+			copy.SetMapIndex(copyGo(iterator.Key(), copies), copyGo(iterator.Value(), copies))
+		}
+		return copy
+
+	default:
+		return value
+	}
+}
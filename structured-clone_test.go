@@ -0,0 +1,323 @@
+package commonjs_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/exturl"
+)
+
+func TestStructuredClone(t *testing.T) {
+	tests := []struct {
+		name           string
+		value          string // in the from runtime
+		expected       string // in the to runtime, with the value as "clone"
+		err            string
+		dataCloneError bool
+	}{
+		{
+			name: "object",
+			value: `
+const value = {array: [1, 'two', null], date: new Date(1000), regexp: /a+/gi};
+value.self = value;
+value;`,
+			expected: `
+clone.self === clone &&
+clone.array.length === 3 && clone.array[1] === 'two' && clone.array[2] === null &&
+clone.date instanceof Date && clone.date.getTime() === 1000 &&
+clone.regexp instanceof RegExp && clone.regexp.flags === 'gi'`,
+		},
+		{
+			name:     "collections",
+			value:    `({map: new Map([['key', {nested: true}]]), set: new Set([1, 2])})`,
+			expected: `clone.map instanceof Map && clone.map.get('key').nested === true && clone.set instanceof Set && clone.set.has(2)`,
+		},
+		{
+			name: "views",
+			value: `
+const buffer = new ArrayBuffer(8);
+new Uint8Array(buffer)[2] = 42;
+({bytes: new Uint8Array(buffer, 2, 4), view: new DataView(buffer)});`,
+			expected: `clone.bytes instanceof Uint8Array && clone.bytes.length === 4 && clone.bytes[0] === 42 && clone.view.buffer === clone.bytes.buffer`,
+		},
+		{
+			name:     "errors",
+			value:    `new TypeError('bad', {cause: new RangeError('cause')})`,
+			expected: `clone instanceof TypeError && clone.message === 'bad' && clone.cause instanceof RangeError`,
+		},
+		{
+			name:     "wrappers",
+			value:    `[new Boolean(false), new String('s'), new Number(1)]`,
+			expected: `clone[0] instanceof Boolean && clone[0].valueOf() === false && clone[1].valueOf() === 's' && clone[2].valueOf() === 1`,
+		},
+		{
+			name:     "sparse array",
+			value:    `[1, , 3]`,
+			expected: `clone.length === 3 && !(1 in clone) && clone[2] === 3`,
+		},
+		{
+			name:     "negative zero",
+			value:    `[-0, new Number(-0)]`,
+			expected: `Object.is(clone[0], -0) && Object.is(clone[1].valueOf(), -0)`,
+		},
+		{
+			name:     "bigint",
+			value:    `12345678901234567890n`,
+			expected: `clone === 12345678901234567890n`,
+		},
+		{
+			name:           "function",
+			value:          `({f: function() {}})`,
+			err:            "function cannot be cloned",
+			dataCloneError: true,
+		},
+		{
+			name:           "symbol",
+			value:          `({s: Symbol('s')})`,
+			err:            "symbol cannot be cloned",
+			dataCloneError: true,
+		},
+		{
+			name:  "throwing getter",
+			value: `({get a() { throw new RangeError('getter'); }})`,
+			err:   "getter",
+		},
+		{
+			name:  "throwing proxy",
+			value: `new Proxy({}, {ownKeys() { throw new RangeError('ownKeys'); }})`,
+			err:   "ownKeys",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			from := goja.New()
+			to := goja.New()
+
+			value, err := from.RunString(test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			clone, err := commonjs.StructuredClone(from, to, value)
+
+			if test.err != "" {
+				if (err == nil) || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected %q: %v", test.err, err)
+				}
+				var dataCloneError *commonjs.DataCloneError
+				if errors.As(err, &dataCloneError) != test.dataCloneError {
+					t.Errorf("unexpected error type: %T", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			to.Set("clone", clone)
+			if result, err := to.RunString(test.expected); err == nil {
+				if !result.ToBoolean() {
+					t.Error("clone is not equivalent")
+				}
+			} else {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestStructuredCloneGo(t *testing.T) {
+	runtime := goja.New()
+
+	shared := []any{int64(1), "two"}
+	value := map[string]any{"a": shared, "b": shared, "empty": []any{}, "none": nil}
+	value["self"] = value
+
+	clone, err := commonjs.StructuredCloneGo(runtime, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtime.Set("clone", clone)
+	if result, err := runtime.RunString(`
+clone.self === clone && clone.a === clone.b &&
+Array.isArray(clone.a) && clone.a[1] === 'two' &&
+Array.isArray(clone.empty) && clone.none === null`); err == nil {
+		if !result.ToBoolean() {
+			t.Error("clone is not equivalent")
+		}
+	} else {
+		t.Error(err)
+	}
+
+	// The clone does not share memory with the value
+	if err := clone.(*goja.Object).Get("a").(*goja.Object).Set("0", 9); err != nil {
+		t.Fatal(err)
+	}
+	if shared[0] != int64(1) {
+		t.Error("clone shares memory with the value")
+	}
+
+	var dataCloneError *commonjs.DataCloneError
+	for _, value := range []any{
+		runtime.NewObject(),
+		map[string]any{"nested": []any{runtime.ToValue(1)}},
+	} {
+		if _, err := commonjs.StructuredCloneGo(runtime, value); !errors.As(err, &dataCloneError) {
+			t.Errorf("expected DataCloneError: %v", err)
+		}
+	}
+}
+
+type testCloneStruct struct {
+	Name  string
+	Items []string
+	Next  *testCloneStruct
+}
+
+func (self *testCloneStruct) Rename(name string) {
+	self.Name = name
+}
+
+func TestStructuredCloneGoTypes(t *testing.T) {
+	items := []string{"a", "b"}
+	pointer := &testCloneStruct{Name: "pointer", Items: items}
+	pointer.Next = pointer
+	date := time.UnixMilli(1000)
+
+	tests := []struct {
+		name      string
+		value     any
+		source    string // has "clone", might mutate it
+		unchanged func() bool
+	}{
+		{
+			name:      "typed slice",
+			value:     items,
+			source:    `Array.isArray(clone) && clone.length === 2 && (clone[0] = 'x') && true`,
+			unchanged: func() bool { return items[0] == "a" },
+		},
+		{
+			name:   "array",
+			value:  [2]int{1, 2},
+			source: `Array.isArray(clone) && clone[1] === 2`,
+		},
+		{
+			name:      "typed map",
+			value:     map[string][]string{"items": items},
+			source:    `(clone.items[0] = 'x') && true`,
+			unchanged: func() bool { return items[0] == "a" },
+		},
+		{
+			name:   "map with other keys",
+			value:  map[int]string{2: "two", 1: "one"},
+			source: `clone instanceof Map && clone.get(1) === 'one' && [...clone.keys()].join() === '1,2'`,
+		},
+		{
+			name:   "bytes",
+			value:  []byte{1, 2, 3},
+			source: `clone instanceof Uint8Array && clone.length === 3 && clone[2] === 3`,
+		},
+		{
+			name:   "time",
+			value:  date,
+			source: `clone instanceof Date && clone.getTime() === 1000`,
+		},
+		{
+			name:      "struct pointer",
+			value:     pointer,
+			source:    `clone.name === 'pointer' && clone.next.name === 'pointer' && (clone.items[0] = 'x') && (clone.rename('renamed') === undefined) && clone.name === 'renamed'`,
+			unchanged: func() bool { return (pointer.Name == "pointer") && (items[0] == "a") },
+		},
+		{
+			name:      "struct",
+			value:     testCloneStruct{Name: "value", Items: items},
+			source:    `clone.name === 'value' && (clone.items[0] = 'x') && true`,
+			unchanged: func() bool { return items[0] == "a" },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runtime := goja.New()
+			runtime.SetFieldNameMapper(commonjs.DromedaryCaseMapper)
+
+			clone, err := commonjs.StructuredCloneGo(runtime, test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			runtime.Set("clone", clone)
+			if result, err := runtime.RunString(test.source); err == nil {
+				if !result.ToBoolean() {
+					t.Error("clone is not equivalent")
+				}
+			} else {
+				t.Error(err)
+			}
+
+			if (test.unchanged != nil) && !test.unchanged() {
+				t.Error("clone shares memory with the value")
+			}
+		})
+	}
+
+	var dataCloneError *commonjs.DataCloneError
+	if _, err := commonjs.StructuredCloneGo(goja.New(), make(chan int)); !errors.As(err, &dataCloneError) {
+		t.Errorf("expected DataCloneError: %v", err)
+	}
+}
+
+func TestStructuredCloneGlobal(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	tests := []struct {
+		name   string
+		source string // must set "ok"
+	}{
+		{
+			name: "copy",
+			source: `
+const original = {list: [1, 2]};
+const clone = structuredClone(original);
+clone.list.push(3);
+ok = original.list.length === 2;`,
+		},
+		{
+			name:   "DataCloneError",
+			source: `try { structuredClone(() => {}); } catch (error) { ok = error.name === 'DataCloneError'; }`,
+		},
+		{
+			name:   "throwing getter",
+			source: `try { structuredClone({get a() { throw new RangeError('getter'); }}); } catch (error) { ok = error instanceof RangeError; }`,
+		},
+		{
+			name:   "throwing proxy",
+			source: `try { structuredClone(new Proxy({}, {ownKeys() { throw new RangeError('ownKeys'); }})); } catch (error) { ok = error instanceof RangeError; }`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			environment.DefineModuleSource(test.name, "let ok = false;\n"+test.source+"\nexports.ok = ok;")
+
+			if exports, err := environment.Require(test.name, false, nil); err == nil {
+				if !exports.Get("ok").ToBoolean() {
+					t.Error("structuredClone global did not behave")
+				}
+			} else {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package commonjs
+
+import (
+	"math"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/dop251/goja"
+)
+
+//
+// TransferRuntime
+//
+
+// A runtime that only holds values while they are on their way from one
+// runtime to another, so that the sender and the receiver never need each
+// other's runtime. Values are copied in and out with [StructuredClone].
+//
+// Safe to share between goroutines.
+type TransferRuntime struct {
+	runtime *goja.Runtime
+	lock    sync.Mutex
+}
+
+func NewTransferRuntime() *TransferRuntime {
+	return &TransferRuntime{
+		runtime: goja.New(),
+	}
+}
+
+// Clones a value from a runtime into the transfer runtime. No one else may be
+// using the from runtime during the call.
+func (self *TransferRuntime) CloneIn(from *goja.Runtime, value goja.Value) (goja.Value, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return StructuredClone(from, self.runtime, value)
+}
+
+// Clones a value from the transfer runtime into a runtime. No one else may be
+// using the to runtime during the call.
+func (self *TransferRuntime) CloneOut(to *goja.Runtime, value goja.Value) (goja.Value, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return StructuredClone(self.runtime, to, value)
+}
+
+// Clones a Go value into the transfer runtime. See [StructuredCloneGo].
+func (self *TransferRuntime) FromGo(value any) (goja.Value, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return StructuredCloneGo(self.runtime, value)
+}
+
+// Exports a value in the transfer runtime (see [goja.Value.Export]).
+func (self *TransferRuntime) ToGo(value goja.Value) any {
+	if value == nil {
+		return nil
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return value.Export()
+}
+
+// Whether two values in the transfer runtime are the same (see sameValue).
+func (self *TransferRuntime) same(a goja.Value, b goja.Value) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return sameValue(a, b, make(map[[2]*goja.Object]struct{}))
+}
+
+// Primitives are compared in the manner of JavaScript's
+// [Object.is](https://developer.mozilla.org/en-US/docs/Web/JavaScript/Reference/Global_Objects/Object/is),
+// so that e.g. NaN is the same as NaN, but 0 is not the same as -0. Objects are
+// compared by class and own enumerable properties, recursively, and objects
+// without such properties (e.g. dates) by their exported values.
+//
+// Can panic.
+func sameValue(a goja.Value, b goja.Value, visited map[[2]*goja.Object]struct{}) bool {
+	aObject, aOk := a.(*goja.Object)
+	bObject, bOk := b.(*goja.Object)
+	if !aOk || !bOk {
+		if isNumber(a) && isNumber(b) {
+			// An integer and a float can be the same number
+			a_ := a.ToFloat()
+			b_ := b.ToFloat()
+			if math.IsNaN(a_) && math.IsNaN(b_) {
+				return true
+			}
+			return (a_ == b_) && (math.Signbit(a_) == math.Signbit(b_))
+		}
+		return a.SameAs(b)
+	}
+
+	if aObject == bObject {
+		return true
+	}
+
+	pair := [2]*goja.Object{aObject, bObject}
+	if _, ok := visited[pair]; ok {
+		// Assume the same until proven otherwise
+		return true
+	}
+	visited[pair] = struct{}{}
+
+	if aObject.ClassName() != bObject.ClassName() {
+		return false
+	}
+
+	if aObject.ClassName() == "Array" {
+		if !sameValue(aObject.Get("length"), bObject.Get("length"), visited) {
+			return false
+		}
+	}
+
+	keys := slices.Sorted(slices.Values(aObject.Keys()))
+	if !slices.Equal(keys, slices.Sorted(slices.Values(bObject.Keys()))) {
+		return false
+	}
+
+	if len(keys) == 0 {
+		return reflect.DeepEqual(aObject.Export(), bObject.Export())
+	}
+
+	for _, key := range keys {
+		if !sameValue(aObject.Get(key), bObject.Get(key), visited) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isNumber(value goja.Value) bool {
+	if (value == nil) || goja.IsUndefined(value) || goja.IsNull(value) {
+		return false
+	}
+
+	switch value.ExportType().Kind() {
+	case reflect.Int64, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
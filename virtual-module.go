@@ -0,0 +1,190 @@
+package commonjs
+
+import (
+	"bytes"
+	contextpkg "context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/tliron/exturl"
+)
+
+const VIRTUAL_URL_SCHEME = "virtual"
+
+// Defines a virtual module with ready-made exports. A "require" of id will
+// return the exports without resolving a URL.
+//
+// Virtual modules are consulted before [Environment.CreateResolver] and are
+// matched against the ID exactly as it is passed to "require", or against
+// "virtual:id". This makes them useful for mocking modules in tests. They are
+// otherwise like any other module: they are cached, appear in "require.cache"
+// (with the key "virtual:id"), and are shared with child environments.
+//
+// The exports can be any value that can be converted to a JavaScript object.
+// Because they are converted for every environment that requires them, they
+// should not be a [goja.Value] belonging to a specific runtime if the module is
+// to be used in child environments (e.g. by "bind").
+//
+// Redefining a module replaces it and clears it from the caches.
+func (self *Environment) DefineModule(id string, exports any) {
+	self.defineModule(id, &virtualModule{exports: exports, hasExports: true})
+}
+
+// Defines a virtual module with JavaScript source code. See
+// [Environment.DefineModule].
+//
+// The source is compiled and run like a module loaded from a URL, including
+// the [Environment.Precompile] step. Relative requires in it are resolved
+// against [Environment.BasePaths].
+func (self *Environment) DefineModuleSource(id string, source string) {
+	self.defineModule(id, &virtualModule{source: source})
+}
+
+// Removes a virtual module and clears it from the caches.
+func (self *Environment) UndefineModule(id string) {
+	self.virtualModules.Delete(id)
+	self.uncacheModule(newVirtualKey(id))
+}
+
+func (self *Environment) defineModule(id string, module *virtualModule) {
+	self.virtualModules.Store(id, module)
+	self.uncacheModule(newVirtualKey(id))
+}
+
+// Also makes the cached exports stale in environments that share the program
+// cache (see: Environment.loadExports).
+func (self *Environment) uncacheModule(key string) {
+	self.addGeneration(key)
+	self.exportsCache.Delete(key)
+	self.uncacheProgram(key)
+	self.Lock.Lock()
+	self.Modules.Delete(key)
+	self.Lock.Unlock()
+}
+
+func (self *Environment) uncacheProgram(key string) {
+	self.programCache.Range(func(key_ any, value any) bool {
+		// See: Context.programKey
+		if (key_ == key) || strings.HasPrefix(key_.(string), key+"|") {
+			self.programCache.Delete(key_)
+		}
+		return true
+	})
+}
+
+func (self *Environment) resolveVirtualModule(id string) (*VirtualURL, bool) {
+	id = strings.TrimPrefix(id, VIRTUAL_URL_SCHEME+":")
+	if module, ok := self.virtualModules.Load(id); ok {
+		return &VirtualURL{
+			Id:         id,
+			module:     module.(*virtualModule),
+			urlContext: self.URLContext,
+		}, true
+	} else {
+		return nil, false
+	}
+}
+
+// Returns the exports if this is a virtual module with ready-made exports.
+func (self *Context) virtualExports() (*goja.Object, bool) {
+	if isVirtualExports(self.URL) {
+		url := self.URL.(*VirtualURL)
+		runtime := self.Environment.Runtime
+
+		var exports *goja.Object
+		if value := runtime.ToValue(url.module.exports); !goja.IsUndefined(value) && !goja.IsNull(value) {
+			exports = value.ToObject(runtime)
+		} else {
+			exports = runtime.NewObject()
+		}
+
+		self.Module.Exports = exports
+		return exports, true
+	} else {
+		return nil, false
+	}
+}
+
+func isVirtualExports(url exturl.URL) bool {
+	virtualUrl, ok := url.(*VirtualURL)
+	return ok && virtualUrl.module.hasExports
+}
+
+//
+// VirtualURL
+//
+
+// The [exturl.URL] of a module defined via [Environment.DefineModule] or
+// [Environment.DefineModuleSource].
+type VirtualURL struct {
+	Id string
+
+	module     *virtualModule
+	urlContext *exturl.Context
+}
+
+// ([fmt.Stringer] interface)
+func (self *VirtualURL) String() string {
+	return self.Key()
+}
+
+// ([exturl.URL] interface)
+func (self *VirtualURL) Format() string {
+	return "js"
+}
+
+// Virtual modules have no base, so this returns the URL itself.
+//
+// ([exturl.URL] interface)
+func (self *VirtualURL) Base() exturl.URL {
+	return self
+}
+
+// Virtual modules have no relative URLs, so this returns the URL itself.
+//
+// ([exturl.URL] interface)
+func (self *VirtualURL) Relative(path string) exturl.URL {
+	return self
+}
+
+// Always returns an error, which allows resolvers to move on to their other
+// base paths.
+//
+// ([exturl.URL] interface)
+func (self *VirtualURL) ValidRelative(context contextpkg.Context, path string) (exturl.URL, error) {
+	return nil, exturl.NewNotFoundf("virtual module %q has no relative paths: %s", self.Id, path)
+}
+
+// ([exturl.URL] interface)
+func (self *VirtualURL) Key() string {
+	return newVirtualKey(self.Id)
+}
+
+// ([exturl.URL] interface)
+func (self *VirtualURL) Open(context contextpkg.Context) (io.ReadCloser, error) {
+	if self.module.hasExports {
+		return nil, fmt.Errorf("virtual module %q has no source", self.Id)
+	}
+	return io.NopCloser(bytes.NewReader([]byte(self.module.source))), nil
+}
+
+// ([exturl.URL] interface)
+func (self *VirtualURL) Context() *exturl.Context {
+	return self.urlContext
+}
+
+//
+// virtualModule
+//
+
+type virtualModule struct {
+	source     string
+	exports    any
+	hasExports bool
+}
+
+func newVirtualKey(id string) string {
+	return VIRTUAL_URL_SCHEME + ":" + id
+}
@@ -0,0 +1,58 @@
+package commonjs_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/exturl"
+)
+
+func TestVirtualModule(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	path := filepath.Join(getRoot(t), "examples")
+
+	environment := commonjs.NewEnvironment(urlContext, urlContext.NewFileURL(path))
+	defer environment.Release()
+
+	environment.DefineModule("config", map[string]any{"name": "test"})
+	// Mock a file module
+	environment.DefineModuleSource("./lib/hello", "exports.sayit = function() { return require('config').name; };")
+	environment.DefineModuleSource("main", "exports.value = require('./lib/hello').sayit();")
+
+	if exports, err := environment.Require("main", false, nil); err == nil {
+		if value := exports.Get("value").String(); value != "test" {
+			t.Errorf("unexpected value: %s", value)
+		}
+	} else {
+		t.Fatal(err)
+	}
+
+	if environment.Modules.Get("virtual:config") == nil {
+		t.Errorf("module not registered: %v", environment.Modules.Keys())
+	}
+
+	child := environment.NewChild()
+	if _, err := child.Require("config", false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Redefine
+	environment.DefineModule("config", map[string]any{"name": "redefined"})
+	for _, environment := range []*commonjs.Environment{environment, child} {
+		if exports, err := environment.Require("config", false, nil); err == nil {
+			if value := exports.Get("name").String(); value != "redefined" {
+				t.Errorf("unexpected value: %s", value)
+			}
+		} else {
+			t.Fatal(err)
+		}
+	}
+
+	environment.UndefineModule("config")
+	if _, err := environment.Require("config", false, nil); err == nil {
+		t.Error("expected an error for an undefined module")
+	}
+}
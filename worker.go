@@ -0,0 +1,354 @@
+package commonjs
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/dop251/goja"
+	"github.com/tliron/exturl"
+)
+
+//
+// Worker
+//
+
+// Runs a module in a child [Environment] on its own goroutine, in the manner of
+// JavaScript's
+// [Worker](https://developer.mozilla.org/en-US/docs/Web/API/Worker).
+//
+// Messages are copied with [StructuredClone] in both directions. In the
+// worker, "postMessage" is a global, and the handler is the global
+// "onmessage". In the parent, they are properties of [Worker.Object]. Events
+// are kept until there is a handler for them.
+//
+// Exceptions thrown in the worker are sent to the parent's "onerror" handler.
+// An exception while loading the module also ends the worker.
+//
+// Handlers in the parent are called via [Environment.Execute], so they are
+// never called while the parent is running other code (see
+// [Environment.Execute] for what that requires).
+//
+// The worker is terminated when the parent environment is released. Its code
+// is not subject to [Environment.ExecutionTimeout], because workers are
+// expected to run for long; use [Worker.Terminate] instead.
+type Worker struct {
+	Object *goja.Object // in the parent's runtime
+
+	jsContext *Context
+	parent    *Environment
+	child     *Environment
+	url       exturl.URL
+
+	transfer *TransferRuntime
+
+	inbox    *eventQueue[workerEvent] // to the worker
+	outbox   *eventQueue[workerEvent] // to the parent
+	messages *workerTarget            // parent's "onmessage"
+	errors   *workerTarget            // parent's "onerror"
+	received *workerTarget            // worker's "onmessage"
+
+	done       chan struct{}
+	terminate  sync.Once
+	unregister func() // see: Environment.onRelease
+}
+
+type workerEvent struct {
+	message goja.Value // in the transfer runtime
+	err     error
+	error_  goja.Value // in the transfer runtime
+}
+
+// Resolves the id relative to the context and starts the worker. Must be
+// called on the context's runtime.
+func (self *Context) NewWorker(id string) (*Worker, error) {
+	context, cancelContext := self.Environment.NewTimeoutContext()
+	defer cancelContext()
+
+	if url, err := self.Resolve(context, id, false); err == nil {
+		worker := Worker{
+			jsContext: self,
+			parent:    self.Environment,
+			child:     self.Environment.NewChild(),
+			url:       url,
+			transfer:  NewTransferRuntime(),
+			inbox:     newEventQueue[workerEvent](),
+			outbox:    newEventQueue[workerEvent](),
+			done:      make(chan struct{}),
+		}
+
+		worker.child.ExecutionTimeout = 0
+
+		worker.installParent()
+		worker.installChild()
+		worker.unregister = self.Environment.onRelease(worker.Terminate)
+
+		go worker.run()
+		go worker.dispatch()
+
+		return &worker, nil
+	} else {
+		return nil, err
+	}
+}
+
+// Sends a message to the worker. Must be called on the parent's runtime.
+func (self *Worker) PostMessage(message goja.Value) error {
+	if transfer, err := self.transfer.CloneIn(self.parent.Runtime, message); err == nil {
+		self.inbox.push(workerEvent{message: transfer})
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Stops the worker immediately, interrupting any code it is running. Pending
+// events in both directions are discarded.
+func (self *Worker) Terminate() {
+	self.terminate.Do(func() {
+		close(self.done)
+		self.unregister()
+		self.child.Runtime.Interrupt("worker terminated")
+	})
+}
+
+func (self *Worker) installParent() {
+	runtime := self.parent.Runtime
+
+	self.Object = runtime.NewObject()
+	self.Object.Set("postMessage", self.PostMessage)
+	self.Object.Set("terminate", self.Terminate)
+
+	self.messages = self.newTarget(self.parent, self.Object, "onmessage", self.deliverMessage, self.logError)
+	self.errors = self.newTarget(self.parent, self.Object, "onerror", self.deliverError, self.logError)
+}
+
+func (self *Worker) installChild() {
+	runtime := self.child.Runtime
+
+	runtime.Set("self", runtime.GlobalObject())
+	runtime.Set("postMessage", func(message goja.Value) error {
+		if transfer, err := self.transfer.CloneIn(runtime, message); err == nil {
+			self.outbox.push(workerEvent{message: transfer})
+			return nil
+		} else {
+			return err
+		}
+	})
+
+	self.received = self.newTarget(self.child, runtime.GlobalObject(), "onmessage", self.deliverMessage, func(err error) {
+		if !self.terminated() {
+			self.postError(err)
+		}
+	})
+}
+
+// The worker's goroutine.
+func (self *Worker) run() {
+	defer self.outbox.close()
+	defer self.child.Release()
+
+	if err := self.child.Execute(func() error {
+		// Only for I/O (the code is not interrupted; see: NewWorker)
+		context, cancelContext := self.child.NewTimeoutContext()
+		defer cancelContext()
+
+		_, err := self.child.NewContext(self.url, self.jsContext, nil).require(context)
+		return err
+	}); err != nil {
+		if !self.terminated() {
+			self.postError(err)
+		}
+		return
+	}
+
+	for {
+		if event, ok := self.inbox.pop(self.done); ok {
+			self.child.Execute(func() error {
+				self.received.push(event)
+				return nil
+			})
+		} else {
+			return
+		}
+	}
+}
+
+// The parent's goroutine for handling events from the worker.
+func (self *Worker) dispatch() {
+	for {
+		if event, ok := self.outbox.pop(self.done); ok {
+			self.parent.Execute(func() error {
+				// Terminate might have been called while we were waiting
+				if self.terminated() {
+					return nil
+				}
+
+				if event.err != nil {
+					if !self.errors.hasHandler() {
+						self.parent.Log.Warningf("worker %s (pending until there is an onerror handler): %s", self.url.String(), event.err.Error())
+					}
+					self.errors.push(event)
+				} else {
+					self.messages.push(event)
+				}
+				return nil
+			})
+		} else {
+			return
+		}
+	}
+}
+
+// Calls the handler with a MessageEvent-like object.
+func (self *Worker) deliverMessage(target *workerTarget, handler goja.Callable, event workerEvent) error {
+	runtime := target.environment.Runtime
+
+	if message, err := self.transfer.CloneOut(runtime, event.message); err == nil {
+		messageEvent := runtime.NewObject()
+		messageEvent.Set("type", "message")
+		messageEvent.Set("data", message)
+		_, err := handler(target.object, messageEvent)
+		return UnwrapJavaScriptException(err)
+	} else {
+		return err
+	}
+}
+
+// Calls the handler with an ErrorEvent-like object.
+func (self *Worker) deliverError(target *workerTarget, handler goja.Callable, event workerEvent) error {
+	runtime := target.environment.Runtime
+
+	errorEvent := runtime.NewObject()
+	errorEvent.Set("type", "error")
+	errorEvent.Set("message", event.err.Error())
+
+	var jsError *JavaScriptError
+	if errors.As(event.err, &jsError) {
+		if frame := jsError.Frame(); frame != nil {
+			errorEvent.Set("filename", frame.File)
+			errorEvent.Set("lineno", frame.Line)
+			errorEvent.Set("colno", frame.Column)
+		}
+	}
+
+	if event.error_ != nil {
+		if error_, err := self.transfer.CloneOut(runtime, event.error_); err == nil {
+			errorEvent.Set("error", error_)
+		}
+	}
+
+	_, err := handler(target.object, errorEvent)
+	return UnwrapJavaScriptException(err)
+}
+
+// Must be called on the worker's runtime.
+func (self *Worker) postError(err error) {
+	event := workerEvent{err: err}
+
+	// Try to send the thrown value itself
+	var jsError *JavaScriptError
+	if errors.As(err, &jsError) && (jsError.Exception != nil) {
+		if transfer, err := self.transfer.CloneIn(self.child.Runtime, jsError.Exception.Value()); err == nil {
+			event.error_ = transfer
+		}
+	}
+
+	self.outbox.push(event)
+}
+
+func (self *Worker) logError(err error) {
+	self.parent.Log.Errorf("worker %s: %s", self.url.String(), err.Error())
+}
+
+func (self *Worker) terminated() bool {
+	select {
+	case <-self.done:
+		return true
+	default:
+		return false
+	}
+}
+
+//
+// workerTarget
+//
+
+// An "on..." handler property. Events are kept until there is a handler.
+//
+// Must only be used on the environment's runtime.
+type workerTarget struct {
+	environment *Environment
+	object      *goja.Object
+	handler     goja.Value
+	pending     []workerEvent
+	deliver     func(target *workerTarget, handler goja.Callable, event workerEvent) error
+	report      func(err error) // for errors thrown by the handler
+	done        chan struct{}
+}
+
+func (self *Worker) newTarget(environment *Environment, object *goja.Object, name string, deliver func(target *workerTarget, handler goja.Callable, event workerEvent) error, report func(err error)) *workerTarget {
+	target := workerTarget{
+		environment: environment,
+		object:      object,
+		handler:     goja.Null(),
+		deliver:     deliver,
+		report:      report,
+		done:        self.done,
+	}
+
+	runtime := environment.Runtime
+	getter := runtime.ToValue(func(call goja.FunctionCall) goja.Value {
+		return target.handler
+	})
+	setter := runtime.ToValue(func(call goja.FunctionCall) goja.Value {
+		target.setHandler(call.Argument(0))
+		return goja.Undefined()
+	})
+	object.DefineAccessorProperty(name, getter, setter, goja.FLAG_FALSE, goja.FLAG_TRUE)
+
+	return &target
+}
+
+func (self *workerTarget) hasHandler() bool {
+	_, ok := goja.AssertFunction(self.handler)
+	return ok
+}
+
+func (self *workerTarget) push(event workerEvent) {
+	self.pending = append(self.pending, event)
+	self.flush()
+}
+
+func (self *workerTarget) setHandler(handler goja.Value) {
+	self.handler = handler
+
+	if self.hasHandler() && (len(self.pending) > 0) {
+		// We are in the middle of running code in the runtime, so the pending
+		// events are delivered when it is done
+		go self.environment.Execute(func() error {
+			self.flush()
+			return nil
+		})
+	}
+}
+
+func (self *workerTarget) flush() {
+	for len(self.pending) > 0 {
+		select {
+		case <-self.done:
+			self.pending = nil
+			return
+		default:
+		}
+
+		if handler, ok := goja.AssertFunction(self.handler); ok {
+			event := self.pending[0]
+			self.pending = self.pending[1:]
+			if err := self.deliver(self, handler, event); err != nil {
+				self.report(err)
+			}
+		} else {
+			return
+		}
+	}
+}
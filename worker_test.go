@@ -0,0 +1,218 @@
+package commonjs_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/tliron/commonjs-goja"
+	"github.com/tliron/commonjs-goja/api"
+	"github.com/tliron/exturl"
+)
+
+func TestWorker(t *testing.T) {
+	tests := []struct {
+		name     string
+		worker   string
+		main     string // has "report"
+		expected []string
+	}{
+		{
+			name:     "echo",
+			worker:   "onmessage = event => postMessage({echo: event.data});",
+			main:     "const worker = new Worker('worker');\nworker.onmessage = event => report(event.data.echo);\nworker.postMessage('hello');",
+			expected: []string{"hello"},
+		},
+		{
+			name:     "order",
+			worker:   "for (let i = 0; i < 3; i++) postMessage(i);\nonmessage = event => postMessage(event.data);",
+			main:     "const worker = new Worker('worker');\nworker.onmessage = event => report(String(event.data));\nworker.postMessage(3);",
+			expected: []string{"0", "1", "2", "3"},
+		},
+		{
+			name:     "exception",
+			worker:   "onmessage = () => { throw new TypeError('failed'); };",
+			main:     "const worker = new Worker('worker');\nworker.onerror = event => report(event.error.name + ' ' + event.error.message);\nworker.postMessage(null);",
+			expected: []string{"TypeError failed"},
+		},
+		{
+			name:     "load exception",
+			worker:   "throw new RangeError('broken');",
+			main:     "const worker = new Worker('worker');\nworker.onerror = event => report(event.error.name + ' ' + event.lineno);",
+			expected: []string{"RangeError 1"},
+		},
+		{
+			name:   "terminate",
+			worker: "onmessage = event => postMessage(event.data);",
+			main:   "const worker = new Worker('worker');\nworker.onmessage = event => report(event.data);\nworker.postMessage('hello');\nworker.terminate();",
+		},
+		{
+			name:     "nested",
+			worker:   "const nested = new Worker('nested');\nnested.onmessage = event => postMessage(event.data + ' from nested');\nonmessage = event => nested.postMessage(event.data);",
+			main:     "const worker = new Worker('worker');\nworker.onmessage = event => report(event.data);\nworker.postMessage('hello');",
+			expected: []string{"hello from nested"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			urlContext := exturl.NewContext()
+			defer urlContext.Release()
+
+			environment := commonjs.NewEnvironment(urlContext)
+			defer environment.Release()
+
+			reports := make(chan string, 10)
+			environment.Extensions = append(api.DefaultExtensions{}.Create(), commonjs.Extension{
+				Name: "report",
+				Create: func(jsContext *commonjs.Context) any {
+					return func(report string) {
+						reports <- report
+					}
+				},
+			})
+
+			environment.DefineModuleSource("worker", test.worker)
+			environment.DefineModuleSource("nested", "onmessage = event => postMessage(event.data);")
+			environment.DefineModuleSource("main", test.main)
+
+			// Handlers are called on the parent's runtime, while other goroutines
+			// require modules and call functions
+			if _, err := environment.Require("main", false, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, expected := range test.expected {
+				select {
+				case report := <-reports:
+					if report != expected {
+						t.Errorf("expected %q: %q", expected, report)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("no report, expected %q", expected)
+				}
+			}
+
+			select {
+			case report := <-reports:
+				t.Errorf("unexpected report: %q", report)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestWorkerTimeouts(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+	defer environment.Release()
+
+	environment.Timeout = 50 * time.Millisecond
+	environment.ExecutionTimeout = 50 * time.Millisecond
+
+	reports := make(chan string, 10)
+	environment.Extensions = append(api.DefaultExtensions{}.Create(), commonjs.Extension{
+		Name: "report",
+		Create: func(jsContext *commonjs.Context) any {
+			return func(report string) {
+				reports <- report
+			}
+		},
+	})
+
+	// Runs for longer than both timeouts
+	environment.DefineModuleSource("worker", "const start = Date.now();\nwhile (Date.now() - start < 200) {}\npostMessage('done');")
+	environment.DefineModuleSource("main", "const worker = new Worker('worker');\nworker.onmessage = event => report(event.data);\nworker.onerror = event => report(event.message);")
+
+	if _, err := environment.Require("main", false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case report := <-reports:
+		if report != "done" {
+			t.Errorf("unexpected report: %q", report)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no report")
+	}
+}
+
+func TestWorkerFromGo(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	environment := commonjs.NewEnvironment(urlContext)
+
+	var ticks atomic.Int64
+	environment.Extensions = commonjs.NewExtensions(map[string]commonjs.CreateExtensionFunc{
+		"tick": func(jsContext *commonjs.Context) any {
+			return func() {
+				ticks.Add(1)
+			}
+		},
+	})
+
+	environment.DefineModuleSource("worker", "postMessage('ready');\nonmessage = () => { for (;;) tick(); };")
+
+	jsContext := environment.NewContext(nil, nil, nil)
+
+	var worker *commonjs.Worker
+	if err := environment.Execute(func() error {
+		var err error
+		worker, err = jsContext.NewWorker("worker")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Let the message arrive before there is a handler
+	time.Sleep(50 * time.Millisecond)
+
+	messages := make(chan any, 10)
+	if err := environment.Execute(func() error {
+		return worker.Object.Set("onmessage", func(event *goja.Object) {
+			messages <- event.Get("data").Export()
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case message := <-messages:
+		if message != "ready" {
+			t.Errorf("unexpected message: %v", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending message was not delivered")
+	}
+
+	if err := environment.Execute(func() error {
+		return worker.PostMessage(goja.Undefined())
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ticks.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("worker is not running")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Releasing the environment terminates the worker
+	if err := environment.Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	ticks_ := ticks.Load()
+	time.Sleep(50 * time.Millisecond)
+	if ticks.Load() != ticks_ {
+		t.Error("worker was not terminated")
+	}
+}